@@ -1,6 +1,17 @@
 package goauth
 
-import "github.com/pquerna/otp/totp"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/hotp"
+	"github.com/pquerna/otp/totp"
+)
 
 // TwoFAMethod provides an interface to provide different 2FA methods
 type TwoFAMethod interface {
@@ -16,69 +27,385 @@ type TwoFAMethod interface {
 	Validate(string, string) bool
 }
 
+// TwoFARegistration is the result of registering a 2FA method for an account
+type TwoFARegistration struct {
+	// Secret is the secret to be stored as 2FA secret of the user
+	Secret string
+
+	// URL is the otpauth:// URL or another method specific registration value
+	URL string
+
+	// QRCode is the rendered URL, if the method is configured to render QR codes
+	QRCode *QRCode
+
+	// RecoveryCodes are the plaintext recovery codes, if enabled
+	RecoveryCodes []string
+}
+
+// TwoFARegistrar is implemented by 2FA methods which provide a detailed
+// registration result, e.g. including a QR code
+type TwoFARegistrar interface {
+	Registration(string) (*TwoFARegistration, error)
+}
+
+// TwoFAUserMethod is implemented by 2FA methods which keep state per user, e.g.
+// a counter. Context.ValidateTwoFA prefers ValidateUser over Validate.
+type TwoFAUserMethod interface {
+	// ValidateUser validates the provided code for the user
+	ValidateUser(user, code, secret string) bool
+}
+
+// TwoFASecretless is implemented by 2FA methods which don't use a secret, e.g.
+// email and SMS codes. Context.ValidateTwoFA doesn't require the 2FA secret of
+// the user for them.
+type TwoFASecretless interface {
+	Secretless() bool
+}
+
+// TwoFAConfirmer is implemented by 2FA methods whose enrollment is confirmed
+// differently than a login, e.g. WebAuthn completes the registration ceremony.
+// Context.ConfirmTwoFA prefers ConfirmUser over ValidateUser and Validate.
+type TwoFAConfirmer interface {
+	// ConfirmUser validates the first code of a pending enrollment
+	ConfirmUser(user, code, secret string) bool
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+/////////////////////////////////////// TOTP METHODS /////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
 // Totp is the deafult TOTP struct
 type Totp struct {
 	name         string
 	secretLength int
 	issuer       string
 	cryptoMethod func(int) ([]byte, error)
+	digits       otp.Digits
+	period       uint
+	algorithm    otp.Algorithm
+	skew         uint
+	qrSize       int
+	qrLevel      string
+
+	// maxDrift limits the recorded clock drift of a user in time steps
+	maxDrift int
+
+	mu    sync.Mutex
+	used  map[string]time.Time
+	drift map[string]int
 }
 
+// TOTPOption represents a TOTP option
+type TOTPOption func(t *Totp)
+
 // TOTP registers TOTP (Time-based one-time password) as a 2FA method and uses the default
 // secret method
-func TOTP(issuer string, n int) AuthenticatorOption {
+func TOTP(issuer string, n int, options ...TOTPOption) AuthenticatorOption {
 	return func(auth *authenticator) {
-		auth.twoFaMethods["totp"] = newTotp(issuer, n, randomCryptoBytes)
+		auth.twoFaMethods["totp"] = newTotp(issuer, n, randomCryptoBytes, options...)
 	}
 }
 
 // TOTPwithSecret registers TOTP (Time-based one-time password) as a 2FA method and you
 // can provide a custom secret function
-func TOTPwithSecret(issuer string, n int, crypto func(int) ([]byte, error)) AuthenticatorOption {
+func TOTPwithSecret(issuer string, n int, crypto func(int) ([]byte, error), options ...TOTPOption) AuthenticatorOption {
 	return func(auth *authenticator) {
-		auth.twoFaMethods["totp"] = newTotp(issuer, n, crypto)
+		auth.twoFaMethods["totp"] = newTotp(issuer, n, crypto, options...)
+	}
+}
+
+// TOTPDigits sets the number of digits of the codes, 6 (default) or 8
+func TOTPDigits(digits int) TOTPOption {
+	return func(t *Totp) {
+		if digits != 6 && digits != 8 {
+			panic("Unsupported number of TOTP digits")
+		}
+		t.digits = otp.Digits(digits)
+	}
+}
+
+// TOTPPeriod sets the number of seconds a code is valid, defaults to 30
+func TOTPPeriod(seconds uint) TOTPOption {
+	return func(t *Totp) {
+		if seconds == 0 {
+			panic("The TOTP period must be > 0")
+		}
+		t.period = seconds
+	}
+}
+
+// TOTPAlgorithm sets the HMAC algorithm: SHA1 (default), SHA256 or SHA512
+func TOTPAlgorithm(algorithm string) TOTPOption {
+	return func(t *Totp) {
+		switch algorithm {
+		case "SHA1":
+			t.algorithm = otp.AlgorithmSHA1
+		case "SHA256":
+			t.algorithm = otp.AlgorithmSHA256
+		case "SHA512":
+			t.algorithm = otp.AlgorithmSHA512
+		default:
+			panic("Unsupported TOTP algorithm")
+		}
+	}
+}
+
+// TOTPSkew sets the number of periods before and after the current one in which
+// codes are accepted, defaults to 1
+func TOTPSkew(skew uint) TOTPOption {
+	return func(t *Totp) {
+		t.skew = skew
+	}
+}
+
+// TOTPQRCode renders the otpauth:// URL of registrations as QR code with about
+// size x size pixels and the error correction level L, M, Q or H
+func TOTPQRCode(size int, level string) TOTPOption {
+	return func(t *Totp) {
+		if size <= 0 {
+			panic("The QR code size must be > 0")
+		}
+
+		if !strings.Contains("LMQH", level) || len(level) != 1 {
+			panic(ErrorQRCodeLevel)
+		}
+
+		t.qrSize = size
+		t.qrLevel = level
 	}
 }
 
-func newTotp(issuer string, n int, c func(int) ([]byte, error)) TwoFAMethod {
+func newTotp(issuer string, n int, c func(int) ([]byte, error), options ...TOTPOption) TwoFAMethod {
 	if n <= 0 {
 		panic(Error2FAInavlidSecretSize)
 	}
 
-	return &Totp{
+	t := &Totp{
 		issuer:       issuer,
 		secretLength: n,
 		cryptoMethod: c,
+		digits:       otp.DigitsSix,
+		period:       30,
+		algorithm:    otp.AlgorithmSHA1,
+		skew:         1,
+		maxDrift:     10,
+		used:         make(map[string]time.Time),
+		drift:        make(map[string]int),
 	}
+
+	for _, f := range options {
+		f(t)
+	}
+
+	return t
 }
 
 func (t *Totp) Generate(account string) (string, error) {
+	_, url, err := t.Register(account)
+	return url, err
+}
+
+func (t *Totp) Register(account string) (string, string, error) {
+	r, err := t.Registration(account)
+	if err != nil {
+		return "", "", err
+	}
+
+	return r.Secret, r.URL, nil
+}
+
+// Registration generates a new secret for the account and returns it with the
+// otpauth:// URL and, if enabled, the QR code of the URL
+func (t *Totp) Registration(account string) (*TwoFARegistration, error) {
 	secret, err := t.Secret()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	key, err := totp.Generate(totp.GenerateOpts{
 		Issuer:      t.issuer,
 		AccountName: account,
 		Secret:      secret,
+		Period:      t.period,
+		Digits:      t.digits,
+		Algorithm:   t.algorithm,
 	})
 
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	r := &TwoFARegistration{
+		Secret: bytesToString(secret),
+		URL:    key.String(),
+	}
+
+	if t.qrSize > 0 {
+		r.QRCode, err = NewQRCode(r.URL, t.qrSize, t.qrLevel)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return key.String(), nil
+	return r, nil
 }
 
-func (t *Totp) Register(account string) (string, string, error) {
-	secret, err := t.Secret()
+func (t *Totp) Secret() ([]byte, error) {
+	return t.cryptoMethod(t.secretLength)
+}
+
+// Validate validates the code. Replays are tracked by a hash of the secret, use
+// ValidateUser to track them per user.
+func (t *Totp) Validate(code, secret string) bool {
+	h := sha256.Sum256([]byte(secret))
+	return t.ValidateUser(hex.EncodeToString(h[:]), code, secret)
+}
+
+// ValidateUser validates the code for the user. Each code is accepted only once
+// per time step. The time step offset the code matched is recorded, so the
+// validation window follows the clock drift of the user's device without
+// widening it for everyone.
+func (t *Totp) ValidateUser(user, code, secret string) bool {
+	period := int64(t.period)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for k, exp := range t.used {
+		if now.After(exp) {
+			delete(t.used, k)
+		}
+	}
+
+	drift := t.drift[user]
+	current := now.Unix() / period
+
+	for _, offset := range stepOffsets(drift, int(t.skew)) {
+		if offset > t.maxDrift || offset < -t.maxDrift {
+			continue
+		}
+
+		step := current + int64(offset)
+		ok, err := totp.ValidateCustom(code, secret, time.Unix(step*period, 0).UTC(), totp.ValidateOpts{
+			Period:    t.period,
+			Digits:    t.digits,
+			Algorithm: t.algorithm,
+		})
+		if err != nil || !ok {
+			continue
+		}
+
+		key := user + ":" + strconv.FormatInt(step, 10)
+		if _, used := t.used[key]; used {
+			return false
+		}
+
+		// The step can be accepted as long as it is within the maximum drift
+		t.used[key] = time.Unix((step+int64(t.maxDrift)+int64(t.skew)+1)*period, 0)
+		t.drift[user] = offset
+		return true
+	}
+
+	return false
+}
+
+// stepOffsets returns the time step offsets around the drift, closest first
+func stepOffsets(drift, skew int) []int {
+	offsets := []int{drift}
+	for i := 1; i <= skew; i++ {
+		offsets = append(offsets, drift-i, drift+i)
+	}
+	return offsets
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+/////////////////////////////////////// HOTP METHODS /////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
+// CounterStore persists the HOTP counter of each user
+type CounterStore interface {
+	// Counter returns the next expected counter of the user
+	Counter(user string) (uint64, error)
+
+	// SetCounter stores the next expected counter of the user
+	SetCounter(user string, counter uint64) error
+}
+
+// Hotp is the default HOTP struct
+type Hotp struct {
+	issuer       string
+	secretLength int
+	window       int
+	resyncWindow int
+	store        CounterStore
+	cryptoMethod func(int) ([]byte, error)
+	mu           sync.Mutex
+}
+
+// HOTP registers HOTP (HMAC-based one-time password) as a 2FA method. The window
+// specifies how many codes ahead of the stored counter are accepted, e.g. when the
+// user pressed the button of their token without logging in. The counter of each
+// user is persisted via the store.
+func HOTP(issuer string, n, window int, store CounterStore) AuthenticatorOption {
+	return func(auth *authenticator) {
+		auth.twoFaMethods["hotp"] = newHotp(issuer, n, window, store)
+	}
+}
+
+func newHotp(issuer string, n, window int, store CounterStore) TwoFAMethod {
+	if n <= 0 {
+		panic(Error2FAInavlidSecretSize)
+	}
+
+	if window < 0 {
+		panic("HOTP window cannot be negative")
+	}
+
+	if store == nil {
+		panic("HOTP counter store cannot be nil")
+	}
+
+	return &Hotp{
+		issuer:       issuer,
+		secretLength: n,
+		window:       window,
+		resyncWindow: 100,
+		store:        store,
+		cryptoMethod: randomCryptoBytes,
+	}
+}
+
+func (h *Hotp) Generate(account string) (string, error) {
+	_, url, err := h.key(account)
+	return url, err
+}
+
+// Register returns a new secret and key URL for the account. The stored counter
+// is left untouched, so the current token keeps working until the new one is
+// confirmed or saved, see ResetCounter.
+func (h *Hotp) Register(account string) (string, string, error) {
+	return h.key(account)
+}
+
+// ResetCounter resets the counter of the user to 0. Call it after saving the
+// secret of a new token registered without two-step enrollment, as the token
+// starts counting at 0.
+func (h *Hotp) ResetCounter(user string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.store.SetCounter(user, 0)
+}
+
+func (h *Hotp) key(account string) (string, string, error) {
+	secret, err := h.Secret()
 	if err != nil {
 		return "", "", err
 	}
 
-	key, err := totp.Generate(totp.GenerateOpts{
-		Issuer:      t.issuer,
+	key, err := hotp.Generate(hotp.GenerateOpts{
+		Issuer:      h.issuer,
 		AccountName: account,
 		Secret:      secret,
 	})
@@ -87,13 +414,82 @@ func (t *Totp) Register(account string) (string, string, error) {
 		return "", "", err
 	}
 
-	return bytesToString(secret), key.String(), nil
+	return bytesToString(secret), key.String() + "&counter=0", nil
 }
 
-func (t *Totp) Secret() ([]byte, error) {
-	return t.cryptoMethod(t.secretLength)
+func (h *Hotp) Secret() ([]byte, error) {
+	return h.cryptoMethod(h.secretLength)
 }
 
-func (t *Totp) Validate(code, secret string) bool {
-	return totp.Validate(code, secret)
+// Validate always fails, as HOTP needs to know the user to look up the counter.
+// Use ValidateUser instead.
+func (h *Hotp) Validate(code, secret string) bool {
+	return false
+}
+
+// ValidateUser validates the code against the counter of the user and the
+// following window codes. On success the counter is moved past the code, so
+// each code can only be used once.
+func (h *Hotp) ValidateUser(user, code, secret string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counter, err := h.store.Counter(user)
+	if err != nil {
+		return false
+	}
+
+	for i := 0; i <= h.window; i++ {
+		if h.validate(code, counter+uint64(i), secret) {
+			return h.store.SetCounter(user, counter+uint64(i)+1) == nil
+		}
+	}
+
+	return false
+}
+
+// ConfirmUser validates the first code of a new token against the counters 0 to
+// window, independent of the stored counter, and stores the counter past the
+// code
+func (h *Hotp) ConfirmUser(user, code, secret string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i := 0; i <= h.window; i++ {
+		if h.validate(code, uint64(i), secret) {
+			return h.store.SetCounter(user, uint64(i)+1) == nil
+		}
+	}
+
+	return false
+}
+
+// Resync resynchronizes the counter of the user, if the token is too far ahead
+// of the stored counter. The user provides two consecutive codes, which are
+// searched for beyond the validation window.
+func (h *Hotp) Resync(user, first, second, secret string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counter, err := h.store.Counter(user)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i <= h.resyncWindow; i++ {
+		c := counter + uint64(i)
+		if h.validate(first, c, secret) && h.validate(second, c+1, secret) {
+			return h.store.SetCounter(user, c+2)
+		}
+	}
+
+	return Error2FAResyncFailed
+}
+
+func (h *Hotp) validate(code string, counter uint64, secret string) bool {
+	ok, err := hotp.ValidateCustom(code, counter, secret, hotp.ValidateOpts{
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	})
+	return ok && err == nil
 }
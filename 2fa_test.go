@@ -0,0 +1,162 @@
+package goauth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const testTotpSecret = "JBSWY3DPEHPK3PXP"
+
+// testTotpPeriod is long enough that the time step doesn't change during a test
+const testTotpPeriod = 3600
+
+func newTestTotp() *Totp {
+	return newTotp("Example", 16, randomCryptoBytes, TOTPPeriod(testTotpPeriod)).(*Totp)
+}
+
+// totpCode returns the code of the time step offset steps from now
+func totpCode(t *testing.T, steps int) string {
+	code, err := totp.GenerateCodeCustom(testTotpSecret, time.Now().Add(time.Duration(steps)*testTotpPeriod*time.Second), totp.ValidateOpts{
+		Period:    testTotpPeriod,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestTotpReplay(t *testing.T) {
+	m := newTestTotp()
+	code := totpCode(t, 0)
+
+	if !m.ValidateUser("alice", code, testTotpSecret) {
+		t.Fatal("first use = false, want true")
+	}
+	if m.ValidateUser("alice", code, testTotpSecret) {
+		t.Error("replay = true, want false")
+	}
+
+	// Codes are tracked per user
+	if !m.ValidateUser("bob", code, testTotpSecret) {
+		t.Error("other user = false, want true")
+	}
+
+	// Without user, codes are tracked per secret
+	if !m.Validate(code, testTotpSecret) {
+		t.Error("Validate first use = false, want true")
+	}
+	if m.Validate(code, testTotpSecret) {
+		t.Error("Validate replay = true, want false")
+	}
+}
+
+func TestTotpReplayAfterDrift(t *testing.T) {
+	m := newTestTotp()
+	code := totpCode(t, 1)
+
+	if !m.ValidateUser("alice", code, testTotpSecret) {
+		t.Fatal("first use = false, want true")
+	}
+
+	// The window moved to the code, it must still be rejected
+	if m.ValidateUser("alice", code, testTotpSecret) {
+		t.Error("replay = true, want false")
+	}
+}
+
+func TestTotpSkew(t *testing.T) {
+	tests := []struct {
+		steps int
+		want  bool
+	}{
+		{-2, false},
+		{-1, true},
+		{0, true},
+		{1, true},
+		{2, false},
+	}
+
+	for _, tt := range tests {
+		m := newTestTotp()
+		if got := m.ValidateUser("alice", totpCode(t, tt.steps), testTotpSecret); got != tt.want {
+			t.Errorf("step %d: got = %v, want %v", tt.steps, got, tt.want)
+		}
+	}
+}
+
+func TestTotpDrift(t *testing.T) {
+	m := newTestTotp()
+
+	// The device runs ahead a step at a time, the window follows it
+	for steps := 1; steps <= 3; steps++ {
+		if !m.ValidateUser("alice", totpCode(t, steps), testTotpSecret) {
+			t.Fatalf("step %d: got = false, want true", steps)
+		}
+	}
+	if m.drift["alice"] != 3 {
+		t.Errorf("drift = %d, want 3", m.drift["alice"])
+	}
+
+	// The window of alice is 2 to 4 now, other users keep theirs
+	if m.ValidateUser("alice", totpCode(t, 0), testTotpSecret) {
+		t.Error("alice step 0: got = true, want false")
+	}
+	if !m.ValidateUser("alice", totpCode(t, 4), testTotpSecret) {
+		t.Error("alice step 4: got = false, want true")
+	}
+	if m.ValidateUser("bob", totpCode(t, 3), testTotpSecret) {
+		t.Error("bob step 3: got = true, want false")
+	}
+	if !m.ValidateUser("bob", totpCode(t, 0), testTotpSecret) {
+		t.Error("bob step 0: got = false, want true")
+	}
+}
+
+func TestTotpMaxDrift(t *testing.T) {
+	m := newTestTotp()
+	m.maxDrift = 2
+
+	for steps := 1; steps <= 2; steps++ {
+		if !m.ValidateUser("alice", totpCode(t, steps), testTotpSecret) {
+			t.Fatalf("step %d: got = false, want true", steps)
+		}
+	}
+
+	if m.ValidateUser("alice", totpCode(t, 3), testTotpSecret) {
+		t.Error("step 3: got = true, want false")
+	}
+	if m.drift["alice"] != 2 {
+		t.Errorf("drift = %d, want 2", m.drift["alice"])
+	}
+}
+
+func TestStepOffsets(t *testing.T) {
+	tests := []struct {
+		drift, skew int
+		want        []int
+	}{
+		{0, 0, []int{0}},
+		{0, 1, []int{0, -1, 1}},
+		{3, 2, []int{3, 2, 4, 1, 5}},
+		{-2, 1, []int{-2, -3, -1}},
+	}
+
+	for _, tt := range tests {
+		got := stepOffsets(tt.drift, tt.skew)
+		if len(got) != len(tt.want) {
+			t.Errorf("stepOffsets(%d, %d) = %v, want %v", tt.drift, tt.skew, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("stepOffsets(%d, %d) = %v, want %v", tt.drift, tt.skew, got, tt.want)
+				break
+			}
+		}
+	}
+}
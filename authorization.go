@@ -0,0 +1,110 @@
+package goauth
+
+import (
+	"strings"
+)
+
+// AuthorizationClaims sets the claims holding the roles and scopes of the user,
+// by default "roles" and "scope". Nested claims are separated by dots, e.g.
+// "realm_access.roles". Claims may be arrays or space-delimited strings.
+func AuthorizationClaims(roles, scopes string) AuthenticatorOption {
+	return func(auth *authenticator) {
+		if roles == "" || scopes == "" {
+			panic("The roles and scopes claims cannot be empty")
+		}
+		auth.rolesClaim = roles
+		auth.scopesClaim = scopes
+	}
+}
+
+// RequireRoles requires the user to have all roles
+func RequireRoles(roles ...string) MiddlewareOption {
+	return func(m *middleware) {
+		m.roles = append(m.roles, roles...)
+	}
+}
+
+// RequireScopes requires the token to have all scopes
+func RequireScopes(scopes ...string) MiddlewareOption {
+	return func(m *middleware) {
+		m.scopes = append(m.scopes, scopes...)
+	}
+}
+
+// RequireAnyOf requires one of the requirements to be met, e.g.
+// RequireAnyOf(RequireRoles("admin"), RequireScopes("users:write"))
+func RequireAnyOf(requirements ...MiddlewareOption) MiddlewareOption {
+	return func(m *middleware) {
+		if len(requirements) == 0 {
+			panic("RequireAnyOf needs at least one requirement")
+		}
+
+		alternatives := make([]*middleware, len(requirements))
+		for i, f := range requirements {
+			alternatives[i] = &middleware{auth: m.auth}
+			f(alternatives[i])
+		}
+		m.anyOf = append(m.anyOf, alternatives)
+	}
+}
+
+// HasRole reports whether the token the context was created from grants the
+// role
+func (c *context) HasRole(role string) bool {
+	return containsString(claimValues(c.claims, c.authenticator.rolesClaim), role)
+}
+
+// HasScope reports whether the token the context was created from grants the
+// scope
+func (c *context) HasScope(scope string) bool {
+	return containsString(claimValues(c.claims, c.authenticator.scopesClaim), scope)
+}
+
+// claimValues returns the values of the claim, which is either an array or a
+// space-delimited string (RFC 8693)
+func claimValues(claims map[string]interface{}, name string) []string {
+	v, _ := attribute(claims, name)
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []string:
+		return v
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// attribute returns the value of the attribute, nested attributes are separated
+// by dots
+func attribute(m map[string]interface{}, name string) (interface{}, bool) {
+	var v interface{} = m
+	for _, key := range strings.Split(name, ".") {
+		mm, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		v, ok = mm[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func containsAll(s []string, values []string) bool {
+	for _, v := range values {
+		if !containsString(s, v) {
+			return false
+		}
+	}
+	return true
+}
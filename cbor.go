@@ -0,0 +1,182 @@
+package goauth
+
+import "math"
+
+// cborMaxDepth limits the nesting of decoded CBOR items
+const cborMaxDepth = 16
+
+// cborDecode decodes the first CBOR item in b. It supports the subset of CBOR
+// (RFC 7049) used by WebAuthn: integers, byte and text strings, arrays, maps,
+// tags, simple values and floats. Integers are returned as int64, maps as
+// map[interface{}]interface{}. The number of consumed bytes is returned as well.
+func cborDecode(b []byte) (interface{}, int, error) {
+	d := &cborDecoder{b: b}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return v, d.off, nil
+}
+
+type cborDecoder struct {
+	b   []byte
+	off int
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, ErrorMalformedCBOR
+	}
+
+	major, info, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, ErrorMalformedCBOR
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, ErrorMalformedCBOR
+		}
+		return -1 - int64(arg), nil
+	case 2, 3:
+		if arg > uint64(len(d.b)-d.off) {
+			return nil, ErrorMalformedCBOR
+		}
+		s := d.b[d.off : d.off+int(arg)]
+		d.off += int(arg)
+		if major == 3 {
+			return string(s), nil
+		}
+		return append([]byte(nil), s...), nil
+	case 4:
+		if arg > uint64(len(d.b)-d.off) {
+			return nil, ErrorMalformedCBOR
+		}
+		a := make([]interface{}, 0, int(arg))
+		for i := uint64(0); i < arg; i++ {
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		return a, nil
+	case 5:
+		if arg > uint64(len(d.b)-d.off) {
+			return nil, ErrorMalformedCBOR
+		}
+		m := make(map[interface{}]interface{}, int(arg))
+		for i := uint64(0); i < arg; i++ {
+			k, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, ErrorMalformedCBOR
+			}
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	case 6:
+		return d.decode(depth + 1)
+	default:
+		return simple(info, arg)
+	}
+}
+
+// head reads the initial byte and argument of a data item. Indefinite lengths
+// are not supported.
+func (d *cborDecoder) head() (byte, byte, uint64, error) {
+	if d.off >= len(d.b) {
+		return 0, 0, 0, ErrorMalformedCBOR
+	}
+
+	major := d.b[d.off] >> 5
+	info := d.b[d.off] & 0x1f
+	d.off++
+
+	var n int
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info == 24:
+		n = 1
+	case info == 25:
+		n = 2
+	case info == 26:
+		n = 4
+	case info == 27:
+		n = 8
+	default:
+		return 0, 0, 0, ErrorMalformedCBOR
+	}
+
+	if len(d.b)-d.off < n {
+		return 0, 0, 0, ErrorMalformedCBOR
+	}
+
+	var arg uint64
+	for i := 0; i < n; i++ {
+		arg = arg<<8 | uint64(d.b[d.off+i])
+	}
+	d.off += n
+
+	return major, info, arg, nil
+}
+
+// simple decodes simple values and floats (major type 7)
+func simple(info byte, arg uint64) (interface{}, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return float16(uint16(arg)), nil
+	case 26:
+		return float64(math.Float32frombits(uint32(arg))), nil
+	case 27:
+		return math.Float64frombits(arg), nil
+	default:
+		return nil, ErrorMalformedCBOR
+	}
+}
+
+func float16(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+
+	var v float64
+	switch exp {
+	case 0:
+		v = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			v = math.Inf(1)
+		} else {
+			v = math.NaN()
+		}
+	default:
+		v = math.Ldexp(mant+1024, exp-25)
+	}
+
+	if h&0x8000 != 0 {
+		return -v
+	}
+	return v
+}
@@ -1,28 +1,55 @@
 package goauth
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
 // Context describes the current authentication context and allows the user to
 // authenticate, validate and register 2FA
 type Context interface {
 	Token() string
 	User() map[string]interface{}
+	Claims() map[string]interface{}
+	ActorChain() []map[string]interface{}
 	Authenticate(map[string]interface{}) error
 	Authenticated() bool
 	SetAuthenticated()
+	TwoFAPending() bool
 	UsesTwoFA() bool
-	TwoFAMethod() string
-	ValidateTwoFA(string) bool
+	TwoFAMethod() (string, error)
+	ValidateTwoFA(string) (bool, error)
 	GenerateTwoFA() (string, error)
 	RegisterTwoFA(string) (string, string, error)
+	EnrollTwoFA(string) (*TwoFARegistration, error)
+	ConfirmTwoFA(string, string) error
+	TrustDevice(string) (*http.Cookie, error)
+	HasRole(string) bool
+	HasScope(string) bool
+	RecoveryCodes() []string
+	ValidateRecoveryCode(string) bool
+	RegenerateRecoveryCodes() ([]string, error)
+	RemainingRecoveryCodes() (int, error)
 	Authenticator() Authenticator
 }
 
 type context struct {
 	user          map[string]interface{}
+	claims        map[string]interface{}
 	authenticated bool
 	token         string
 	twoFAValid    bool
+	twoFAVerified bool
+	deviceExpires time.Time
 	twoFAMap      map[string]interface{}
-	authenticator Authenticator
+	amr           []string
+	authTime      time.Time
+	pendingID     string
+	recoveryCodes []string
+	authenticator *authenticator
 }
 
 func (c *context) Token() string {
@@ -33,6 +60,17 @@ func (c *context) User() map[string]interface{} {
 	return c.user
 }
 
+// Claims returns the claims of the token the context was created from
+func (c *context) Claims() map[string]interface{} {
+	return c.claims
+}
+
+// ActorChain returns the actors which act on behalf of the user, starting with
+// the current actor. The chain is empty if the user acts by themselves.
+func (c *context) ActorChain() []map[string]interface{} {
+	return actorChain(c.claims)
+}
+
 func (c *context) Authenticated() bool {
 	return c.authenticated
 }
@@ -41,40 +79,457 @@ func (c *context) SetAuthenticated() {
 	c.authenticated = true
 }
 
+// TwoFAPending reports whether the context was restored from a "2FA pending"
+// token, i.e. the user passed the first factor and has to validate 2FA
+func (c *context) TwoFAPending() bool {
+	return c.pendingID != ""
+}
+
+// Authenticate creates the token of the user. If the user uses 2FA and no code
+// was validated yet, a short-lived "2FA pending" token is created instead and
+// Error2FANotValidated is returned. The pending token is rejected by the
+// middlewares. Once the user submits it along with a valid code, Authenticate
+// creates the full token. Its "amr" claim records the factors used and
+// "auth_time" when the user authenticated.
 func (c *context) Authenticate(claims map[string]interface{}) error {
-	// if !c.twoFAValid && c.UsesTwoFA() {
-	// 	return Error2FANotValidated
-	// }
+	if c.UsesTwoFA() && !c.twoFAValid {
+		return c.authenticatePending()
+	}
+
+	// Reissued tokens keep the time of the original authentication
+	if c.authTime.IsZero() {
+		c.authTime = time.Now()
+	}
 
 	// TODO: Set exp time stamp
 	claims["user"] = c.User()
+	claims["auth_time"] = c.authTime.Unix()
+	if len(c.amr) > 0 {
+		claims["amr"] = c.amr
+	}
+	if verified, ok := c.emailVerified(); ok {
+		claims["email_verified"] = verified
+	}
+
 	token, err := c.authenticator.AuthMethod().Create(claims)
 	c.token = token
-	return err
+	if err != nil {
+		return err
+	}
+
+	if c.pendingID != "" {
+		c.authenticator.pending.remove(c.pendingID)
+		c.pendingID = ""
+	}
+
+	return nil
 }
 
-func (c *context) ValidateTwoFA(code string) bool {
-	m := c.authenticator.TwoFAMethod(c.TwoFAMethod())
-	c.twoFAValid = m.Validate(code, c.twoFAMap["twofa_secret"].(string))
-	return c.twoFAValid
+// authenticatePending creates the "2FA pending" token. The user data stays on the
+// server, the token only references it.
+func (c *context) authenticatePending() error {
+	id, expires, err := c.authenticator.pending.add(c)
+	if err != nil {
+		return err
+	}
+
+	token, err := c.authenticator.AuthMethod().Create(map[string]interface{}{
+		"jti":           id,
+		"twofa_pending": true,
+		"amr":           c.amr,
+		"iat":           time.Now().Unix(),
+		"exp":           expires.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	c.token = token
+	c.pendingID = id
+	return Error2FANotValidated
+}
+
+// ValidateTwoFA validates the code with the 2FA method of the user. An error is
+// returned if the 2FA data of the user is incomplete.
+func (c *context) ValidateTwoFA(code string) (bool, error) {
+	c.twoFAValid = false
+	c.twoFAVerified = false
+	if !c.pendingValid() {
+		return false, nil
+	}
+
+	method, m, err := c.twoFAMethod()
+	if err != nil {
+		return false, err
+	}
+
+	var secret string
+	if s, ok := m.(TwoFASecretless); !ok || !s.Secretless() {
+		secret, err = c.twoFAString("twofa_secret", Error2FASecretMissing)
+		if err != nil {
+			return false, err
+		}
+
+		if IsSealed(secret) {
+			secret, err = c.openSecret(secret)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	var user string
+	if _, ok := m.(TwoFAUserMethod); ok {
+		user, err = c.twoFAString("twofa_user", Error2FAUserMissing)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	key := c.twoFAKey()
+	err = c.authenticator.twoFAAttempts.take(key)
+	if err != nil {
+		return false, err
+	}
+
+	if u, ok := m.(TwoFAUserMethod); ok {
+		c.twoFAValid = u.ValidateUser(user, code, secret)
+	} else {
+		c.twoFAValid = m.Validate(code, secret)
+	}
+
+	c.twoFAVerified = c.twoFAValid
+	return c.twoFAValid, c.recordTwoFA(method, key)
+}
+
+// pendingValid reports whether the pending login of the context, if any, can
+// still be completed
+func (c *context) pendingValid() bool {
+	if c.pendingID == "" {
+		return true
+	}
+
+	_, ok := c.authenticator.pending.get(c.pendingID)
+	return ok
+}
+
+// recordTwoFA records the factor of a 2FA validation and resets the failed
+// attempts of the user. Failed validations of a pending login are counted, so
+// codes can't be brute-forced with one token.
+func (c *context) recordTwoFA(method, key string) error {
+	if c.twoFAValid {
+		c.amr = appendFactor(c.amr, amrValue(method))
+		return c.authenticator.twoFAAttempts.succeed(key)
+	}
+
+	if c.pendingID != "" {
+		c.authenticator.pending.fail(c.pendingID)
+	}
+	return nil
+}
+
+// twoFAKey returns the key the failed 2FA codes of the user are counted with,
+// the 2FA user or a hash of the secret
+func (c *context) twoFAKey() string {
+	if user, _ := c.twoFAMap["twofa_user"].(string); user != "" {
+		return "2fa:" + user
+	}
+
+	secret, _ := c.twoFAMap["twofa_secret"].(string)
+	sum := sha256.Sum256([]byte(secret))
+	return "2fa:" + hex.EncodeToString(sum[:])
+}
+
+// amrValue maps 2FA methods to authentication method reference values (RFC 8176)
+func amrValue(method string) string {
+	switch method {
+	case "totp", "hotp", "email", "recovery":
+		return "otp"
+	case "webauthn":
+		return "hwk"
+	default:
+		return method
+	}
+}
+
+// claimsAMR returns the factors recorded in the "amr" claim
+func claimsAMR(claims map[string]interface{}) []string {
+	switch v := claims["amr"].(type) {
+	case []string:
+		return v
+	case []interface{}:
+		amr := make([]string, 0, len(v))
+		for _, f := range v {
+			if s, ok := f.(string); ok {
+				amr = append(amr, s)
+			}
+		}
+		return amr
+	default:
+		return nil
+	}
+}
+
+// claimsAuthTime returns the time of the "auth_time" claim or the zero time
+func claimsAuthTime(claims map[string]interface{}) time.Time {
+	var sec int64
+	switch v := claims["auth_time"].(type) {
+	case float64:
+		sec = int64(v)
+	case int64:
+		sec = v
+	case json.Number:
+		sec, _ = v.Int64()
+	default:
+		return time.Time{}
+	}
+
+	return time.Unix(sec, 0)
+}
+
+func appendFactor(amr []string, factor string) []string {
+	if containsString(amr, factor) {
+		return amr
+	}
+	return append(append([]string(nil), amr...), factor)
+}
+
+// openSecret opens a 2FA secret sealed by the keyring for the 2FA user
+func (c *context) openSecret(secret string) (string, error) {
+	if c.authenticator.keyring == nil {
+		return "", ErrorKeyringVersion
+	}
+
+	user, err := c.twoFAString("twofa_user", Error2FAUserMissing)
+	if err != nil {
+		return "", err
+	}
+
+	return c.authenticator.keyring.Open(user, secret)
 }
 
 func (c *context) GenerateTwoFA() (string, error) {
-	m := c.authenticator.TwoFAMethod(c.TwoFAMethod())
-	return m.Generate(c.twoFAMap["twofa_user"].(string))
+	_, m, err := c.twoFAMethod()
+	if err != nil {
+		return "", err
+	}
+
+	user, err := c.twoFAString("twofa_user", Error2FAUserMissing)
+	if err != nil {
+		return "", err
+	}
+
+	return m.Generate(user)
 }
 
 func (c *context) RegisterTwoFA(account string) (string, string, error) {
-	m := c.authenticator.TwoFAMethod(c.TwoFAMethod())
-	return m.Register(account)
+	r, err := c.EnrollTwoFA(account)
+	if err != nil {
+		return "", "", err
+	}
+
+	return r.Secret, r.URL, nil
+}
+
+// EnrollTwoFA registers the 2FA method of the user for the account. Contrary to
+// RegisterTwoFA, the result includes the QR code and recovery codes, if enabled.
+// With two-step enrollment, the secret is held until ConfirmTwoFA and the
+// recovery codes are generated on confirmation. Users who use 2FA have to
+// validate a code first and can only enroll their 2FA user.
+func (c *context) EnrollTwoFA(account string) (*TwoFARegistration, error) {
+	account, err := c.enrollmentAccount(account)
+	if err != nil {
+		return nil, err
+	}
+
+	method, m, err := c.twoFAMethod()
+	if err != nil {
+		return nil, err
+	}
+
+	var r *TwoFARegistration
+	if reg, ok := m.(TwoFARegistrar); ok {
+		r, err = reg.Registration(account)
+	} else {
+		r = &TwoFARegistration{}
+		r.Secret, r.URL, err = m.Register(account)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if c.authenticator.enrollment != nil {
+		return r, c.authenticator.enrollment.hold(account, method, r.Secret)
+	}
+
+	if c.authenticator.recovery != nil {
+		c.recoveryCodes, err = c.authenticator.recovery.generate(account)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r.RecoveryCodes = c.recoveryCodes
+	return r, nil
+}
+
+// ConfirmTwoFA completes a two-step enrollment of the account with the first
+// code generated by the user. On success the secret is persisted and the user is
+// marked as using 2FA. The code doesn't count as second factor of the login.
+// Recovery codes, if enabled, are available via RecoveryCodes afterwards.
+func (c *context) ConfirmTwoFA(account, code string) error {
+	if c.authenticator.enrollment == nil {
+		return ErrorEnrollmentDisabled
+	}
+
+	account, err := c.enrollmentAccount(account)
+	if err != nil {
+		return err
+	}
+
+	method, secret, err := c.authenticator.enrollment.complete(account, code, c.authenticator.TwoFAMethod, c.authenticator.keyring)
+	if err != nil {
+		return err
+	}
+
+	if c.twoFAMap == nil {
+		c.twoFAMap = make(map[string]interface{})
+	}
+
+	c.twoFAMap["uses_twofa"] = true
+	c.twoFAMap["twofa_method"] = method
+	c.twoFAMap["twofa_secret"] = secret
+	c.twoFAMap["twofa_user"] = account
+
+	// The login passed 2FA before or the user didn't use it, see enrollmentAccount
+	c.twoFAValid = true
+
+	if c.authenticator.recovery != nil {
+		c.recoveryCodes, err = c.authenticator.recovery.generate(account)
+	}
+
+	return err
+}
+
+// enrollmentAccount returns the account 2FA is enrolled for. Logins pending 2FA
+// can't enroll, users who use 2FA have to validate a code first. If the user
+// has a 2FA user, only it can be enrolled.
+func (c *context) enrollmentAccount(account string) (string, error) {
+	if c.TwoFAPending() || (c.UsesTwoFA() && !c.twoFAValid) {
+		return "", Error2FANotValidated
+	}
+
+	user, _ := c.twoFAMap["twofa_user"].(string)
+	switch {
+	case user == "" && account == "":
+		return "", Error2FAUserMissing
+	case user == "":
+		return account, nil
+	case account != "" && account != user:
+		return "", ErrorUserMismatch
+	default:
+		return user, nil
+	}
 }
 
-func (c *context) TwoFAMethod() string {
-	return c.twoFAMap["twofa_method"].(string)
+// RecoveryCodes returns the plaintext recovery codes generated by RegisterTwoFA or
+// RegenerateRecoveryCodes. Show them to the user once, they can't be retrieved
+// later on.
+func (c *context) RecoveryCodes() []string {
+	return c.recoveryCodes
 }
 
+// ValidateRecoveryCode validates and consumes a recovery code. It can be used
+// instead of ValidateTwoFA if the user lost their 2FA device.
+func (c *context) ValidateRecoveryCode(code string) bool {
+	if c.authenticator.recovery == nil || !c.pendingValid() {
+		return false
+	}
+
+	user, err := c.twoFAString("twofa_user", Error2FAUserMissing)
+	if err != nil {
+		return false
+	}
+
+	key := c.twoFAKey()
+	if c.authenticator.twoFAAttempts.take(key) != nil {
+		return false
+	}
+
+	c.twoFAValid = c.authenticator.recovery.consume(user, code)
+	return c.recordTwoFA("recovery", key) == nil && c.twoFAValid
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of the user with a new batch
+func (c *context) RegenerateRecoveryCodes() ([]string, error) {
+	if c.authenticator.recovery == nil {
+		return nil, ErrorRecoveryCodesDisabled
+	}
+
+	user, err := c.twoFAString("twofa_user", Error2FAUserMissing)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := c.authenticator.recovery.generate(user)
+	if err != nil {
+		return nil, err
+	}
+
+	c.recoveryCodes = codes
+	return codes, nil
+}
+
+// RemainingRecoveryCodes returns the number of unused recovery codes of the user
+func (c *context) RemainingRecoveryCodes() (int, error) {
+	if c.authenticator.recovery == nil {
+		return 0, ErrorRecoveryCodesDisabled
+	}
+
+	user, err := c.twoFAString("twofa_user", Error2FAUserMissing)
+	if err != nil {
+		return 0, err
+	}
+
+	return c.authenticator.recovery.remaining(user)
+}
+
+// TwoFAMethod returns the name of the 2FA method of the user
+func (c *context) TwoFAMethod() (string, error) {
+	return c.twoFAString("twofa_method", Error2FAMethodMissing)
+}
+
+// UsesTwoFA reports whether the user has 2FA enabled
 func (c *context) UsesTwoFA() bool {
-	return c.twoFAMap["uses_twofa"].(bool)
+	uses, _ := c.twoFAMap["uses_twofa"].(bool)
+	return uses
+}
+
+// twoFAMethod returns the name and the registered 2FA method of the user
+func (c *context) twoFAMethod() (string, TwoFAMethod, error) {
+	method, err := c.TwoFAMethod()
+	if err != nil {
+		return "", nil, err
+	}
+
+	m := c.authenticator.TwoFAMethod(method)
+	if m == nil {
+		return "", nil, Error2FAMethodUnknown
+	}
+
+	return method, m, nil
+}
+
+// twoFAString returns the 2FA value of the user stored under key or the error
+// if the value is missing
+func (c *context) twoFAString(key string, missing error) (string, error) {
+	s, _ := c.twoFAMap[key].(string)
+	if s == "" {
+		return "", missing
+	}
+
+	return s, nil
 }
 
 func (c *context) Authenticator() Authenticator {
@@ -0,0 +1,149 @@
+package goauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"math/big"
+)
+
+// COSE algorithm identifiers supported for WebAuthn credentials
+const (
+	COSEAlgorithmES256 int64 = -7
+	COSEAlgorithmEdDSA int64 = -8
+	COSEAlgorithmRS256 int64 = -257
+)
+
+// COSE key parameters (RFC 8152)
+const (
+	coseKeyType    = 1
+	coseAlgorithm  = 3
+	coseCurve      = -1
+	coseX          = -2
+	coseY          = -3
+	coseRSAModulus = -1
+	coseRSAExp     = -2
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+// coseKey is a parsed COSE_Key public key
+type coseKey struct {
+	alg int64
+	pub crypto.PublicKey
+}
+
+// parseCOSEKey parses the CBOR encoded COSE_Key and returns the number of
+// consumed bytes
+func parseCOSEKey(b []byte) (*coseKey, int, error) {
+	v, n, err := cborDecode(b)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, ErrorUnsupportedCOSEKey
+	}
+
+	kty, _ := m[int64(coseKeyType)].(int64)
+	alg, _ := m[int64(coseAlgorithm)].(int64)
+	key := &coseKey{alg: alg}
+
+	switch {
+	case kty == coseKeyTypeEC2 && alg == COSEAlgorithmES256:
+		crv, _ := m[int64(coseCurve)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, ErrorUnsupportedCOSEKey
+		}
+
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, 0, ErrorUnsupportedCOSEKey
+		}
+		key.pub = pub
+	case kty == coseKeyTypeRSA && alg == COSEAlgorithmRS256:
+		mod, _ := m[int64(coseRSAModulus)].([]byte)
+		exp, _ := m[int64(coseRSAExp)].([]byte)
+		if len(mod) < 256 || len(exp) == 0 || len(exp) > 4 {
+			return nil, 0, ErrorUnsupportedCOSEKey
+		}
+
+		key.pub = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(mod),
+			E: int(new(big.Int).SetBytes(exp).Int64()),
+		}
+	case kty == coseKeyTypeOKP && alg == COSEAlgorithmEdDSA:
+		crv, _ := m[int64(coseCurve)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		if crv != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, ErrorUnsupportedCOSEKey
+		}
+		key.pub = ed25519.PublicKey(x)
+	default:
+		return nil, 0, ErrorUnsupportedCOSEKey
+	}
+
+	return key, n, nil
+}
+
+// verify verifies the signature over data with the key
+func (k *coseKey) verify(data, sig []byte) error {
+	return verifySignature(k.pub, k.alg, data, sig)
+}
+
+// verifySignature verifies a WebAuthn signature made with the COSE algorithm alg
+func verifySignature(pub crypto.PublicKey, alg int64, data, sig []byte) error {
+	switch alg {
+	case COSEAlgorithmES256:
+		p, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrorWebauthnSignature
+		}
+
+		var s struct{ R, S *big.Int }
+		rest, err := asn1.Unmarshal(sig, &s)
+		if err != nil || len(rest) != 0 {
+			return ErrorWebauthnSignature
+		}
+
+		h := sha256.Sum256(data)
+		if !ecdsa.Verify(p, h[:], s.R, s.S) {
+			return ErrorWebauthnSignature
+		}
+	case COSEAlgorithmRS256:
+		p, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return ErrorWebauthnSignature
+		}
+
+		h := sha256.Sum256(data)
+		if rsa.VerifyPKCS1v15(p, crypto.SHA256, h[:], sig) != nil {
+			return ErrorWebauthnSignature
+		}
+	case COSEAlgorithmEdDSA:
+		p, ok := pub.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(p, data, sig) {
+			return ErrorWebauthnSignature
+		}
+	default:
+		return ErrorUnsupportedCOSEKey
+	}
+
+	return nil
+}
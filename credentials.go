@@ -0,0 +1,86 @@
+package goauth
+
+import (
+	"reflect"
+	"sync"
+)
+
+// dummyPassword is verified if the user wasn't found, so the response time
+// doesn't reveal which users exist
+type dummyPassword struct {
+	once sync.Once
+	hash string
+}
+
+// LookupVerifiesCredentials accepts stored users without a field tagged with
+// goauth:"password", e.g. if the lookup function checks the credentials itself.
+// By default such users are rejected.
+func LookupVerifiesCredentials() AuthenticatorOption {
+	return func(auth *authenticator) {
+		auth.lookupVerifies = true
+	}
+}
+
+// verifyCredentials verifies the submitted password against the password hash
+// of the stored user, i.e. the field tagged with goauth:"password". Stored users
+// without a password hash are rejected, unless LookupVerifiesCredentials is set
+// and the field is missing.
+func (auth *authenticator) verifyCredentials(stored interface{}, password string) (bool, error) {
+	if isNil(stored) {
+		auth.verifyDummy(password)
+		return false, nil
+	}
+
+	hash, ok := getTags(stored)["password"]
+	if !ok && auth.lookupVerifies {
+		return true, nil
+	}
+
+	h, _ := hash.(string)
+	if h == "" {
+		auth.verifyDummy(password)
+		return false, nil
+	}
+
+	return auth.VerifyPassword(stored, password, h)
+}
+
+func (auth *authenticator) verifyDummy(password string) {
+	auth.dummy.once.Do(func() {
+		auth.dummy.hash, _ = auth.hasher.Hash("goauth-dummy-password")
+	})
+
+	auth.hasher.Verify(password, auth.dummy.hash)
+}
+
+// userMap converts the user into the map used as "user" claim. The password
+// is removed.
+func userMap(user interface{}) (map[string]interface{}, error) {
+	var m map[string]interface{}
+	err := interfaceToMap(user, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range getDeepTags(reflect.ValueOf(user), true) {
+		if t.Name == "password" && t.Key != "" {
+			delete(m, t.Key)
+		}
+	}
+
+	return m, nil
+}
+
+func isNil(i interface{}) bool {
+	if i == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(i)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Interface, reflect.Slice:
+		return v.IsNil()
+	default:
+		return false
+	}
+}
@@ -1,8 +1,12 @@
 package goauth
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base32"
+	"fmt"
+	"math/big"
 )
 
 func randomCryptoString(n int) (string, error) {
@@ -28,3 +32,59 @@ func randomCryptoBytes(n int) ([]byte, error) {
 func bytesToString(b []byte) string {
 	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
 }
+
+func randomDigits(n int) (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+	v, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%0*s", n, v.String()), nil
+}
+
+// seal encrypts and authenticates the plaintext with AES-GCM. The random nonce is
+// prepended to the ciphertext.
+func seal(key, plaintext, additional []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := randomCryptoBytes(gcm.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+// open decrypts and verifies a ciphertext created by seal
+func open(key, ciphertext, additional []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, ErrorDecryptionFailed
+	}
+
+	n := gcm.NonceSize()
+	plaintext, err := gcm.Open(nil, ciphertext[:n], ciphertext[n:], additional)
+	if err != nil {
+		return nil, ErrorDecryptionFailed
+	}
+
+	return plaintext, nil
+}
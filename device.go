@@ -0,0 +1,296 @@
+package goauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TrustedDeviceCookie is the name of the cookie holding the trusted device token
+const TrustedDeviceCookie = "goauth_device"
+
+// TrustedDevice is a device on which the user chose to skip 2FA
+type TrustedDevice struct {
+	ID       string    `json:"id"`
+	User     string    `json:"user"`
+	Name     string    `json:"name"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used"`
+	Expires  time.Time `json:"expires"`
+}
+
+// DeviceStore stores the trusted devices of each user
+type DeviceStore interface {
+	// SaveDevice creates or updates the device
+	SaveDevice(TrustedDevice) error
+
+	// Device returns the device of the user or ErrorDeviceNotFound
+	Device(user, id string) (TrustedDevice, error)
+
+	// Devices returns all devices of the user
+	Devices(user string) ([]TrustedDevice, error)
+
+	// RemoveDevice removes the device of the user
+	RemoveDevice(user, id string) error
+}
+
+type devices struct {
+	key      []byte
+	lifetime time.Duration
+	store    DeviceStore
+}
+
+// TrustedDevices lets users skip 2FA on devices they trust. After a successful
+// 2FA validation Context.TrustDevice issues a cookie, which is signed with key and
+// bound to the user and the device. The store defaults to an in-memory store.
+func TrustedDevices(key []byte, lifetime time.Duration, store DeviceStore) AuthenticatorOption {
+	return func(auth *authenticator) {
+		if len(key) < 32 {
+			panic("The trusted device key must be at least 32 bytes long")
+		}
+
+		if lifetime <= 0 {
+			panic("The trusted device lifetime must be > 0")
+		}
+
+		if store == nil {
+			store = NewMemoryDeviceStore()
+		}
+
+		auth.devices = &devices{
+			key:      key,
+			lifetime: lifetime,
+			store:    store,
+		}
+	}
+}
+
+// TrustedDevices returns the trusted devices of the user
+func (auth *authenticator) TrustedDevices(user string) ([]TrustedDevice, error) {
+	if auth.devices == nil {
+		return nil, ErrorTrustedDevicesDisabled
+	}
+
+	return auth.devices.store.Devices(user)
+}
+
+// RevokeDevice revokes the trusted device of the user, 2FA is required again on
+// the next login from this device
+func (auth *authenticator) RevokeDevice(user, id string) error {
+	if auth.devices == nil {
+		return ErrorTrustedDevicesDisabled
+	}
+
+	return auth.devices.store.RemoveDevice(user, id)
+}
+
+// RevokeDevices revokes all trusted devices of the user
+func (auth *authenticator) RevokeDevices(user string) error {
+	if auth.devices == nil {
+		return ErrorTrustedDevicesDisabled
+	}
+
+	devices, err := auth.devices.store.Devices(user)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range devices {
+		err = auth.devices.store.RemoveDevice(user, d.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TrustDevice trusts the current device of the user, so 2FA is skipped on the
+// next logins. The returned cookie has to be set in the response. A 2FA code has
+// to be validated with ValidateTwoFA or ConfirmTwoFA first, skipping 2FA on a
+// trusted device doesn't suffice. If the context was created on a trusted
+// device, the new trust expires with the previous one.
+func (c *context) TrustDevice(name string) (*http.Cookie, error) {
+	d := c.authenticator.devices
+	if d == nil {
+		return nil, ErrorTrustedDevicesDisabled
+	}
+
+	if !c.twoFAVerified {
+		return nil, Error2FANotValidated
+	}
+
+	user, err := c.twoFAString("twofa_user", Error2FAUserMissing)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := randomCryptoString(15)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expires := now.Add(d.lifetime)
+	if !c.deviceExpires.IsZero() && c.deviceExpires.Before(expires) {
+		expires = c.deviceExpires
+	}
+
+	device := TrustedDevice{
+		ID:       strings.ToLower(id),
+		User:     user,
+		Name:     name,
+		Created:  now,
+		LastUsed: now,
+		Expires:  expires,
+	}
+
+	err = d.store.SaveDevice(device)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Cookie{
+		Name:     TrustedDeviceCookie,
+		Value:    d.token(device),
+		Path:     "/",
+		Expires:  device.Expires,
+		MaxAge:   int(expires.Sub(now).Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}, nil
+}
+
+// token creates the device token in the form <id>.<expires>.<signature>
+func (d *devices) token(device TrustedDevice) string {
+	payload := device.ID + "." + strconv.FormatInt(device.Expires.Unix(), 10)
+	return payload + "." + d.sign(device.User, payload)
+}
+
+func (d *devices) sign(user, payload string) string {
+	mac := hmac.New(sha256.New, d.key)
+	mac.Write([]byte(user))
+	mac.Write([]byte{0})
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// trusted reports whether the request comes from a trusted device of the user
+// and returns when the trust expires
+func (d *devices) trusted(user string, r *http.Request) (time.Time, bool) {
+	if user == "" || r == nil {
+		return time.Time{}, false
+	}
+
+	cookie, err := r.Cookie(TrustedDeviceCookie)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(d.sign(user, payload))) {
+		return time.Time{}, false
+	}
+
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return time.Time{}, false
+	}
+
+	// The device has to be known, so revoked devices are rejected
+	device, err := d.store.Device(user, parts[0])
+	if err != nil || time.Now().After(device.Expires) {
+		return time.Time{}, false
+	}
+
+	device.LastUsed = time.Now()
+	d.store.SaveDevice(device)
+	return device.Expires, true
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+/////////////////////////////////// MEMORY DEVICE STORE //////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
+// MemoryDeviceStore is an in-memory DeviceStore, e.g. for development
+type MemoryDeviceStore struct {
+	mu      sync.RWMutex
+	devices map[string]map[string]TrustedDevice
+}
+
+// NewMemoryDeviceStore returns an empty in-memory device store
+func NewMemoryDeviceStore() *MemoryDeviceStore {
+	return &MemoryDeviceStore{
+		devices: make(map[string]map[string]TrustedDevice),
+	}
+}
+
+// SaveDevice creates or updates the device
+func (s *MemoryDeviceStore) SaveDevice(d TrustedDevice) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.devices[d.User] == nil {
+		s.devices[d.User] = make(map[string]TrustedDevice)
+	}
+	s.devices[d.User][d.ID] = d
+	return nil
+}
+
+// Device returns the device of the user
+func (s *MemoryDeviceStore) Device(user, id string) (TrustedDevice, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	d, ok := s.devices[user][id]
+	if !ok {
+		return TrustedDevice{}, ErrorDeviceNotFound
+	}
+	return d, nil
+}
+
+// Devices returns all devices of the user, expired devices are removed
+func (s *MemoryDeviceStore) Devices(user string) ([]TrustedDevice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	devices := make([]TrustedDevice, 0, len(s.devices[user]))
+	for id, d := range s.devices[user] {
+		if now.After(d.Expires) {
+			delete(s.devices[user], id)
+			continue
+		}
+		devices = append(devices, d)
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Created.Before(devices[j].Created)
+	})
+	return devices, nil
+}
+
+// RemoveDevice removes the device of the user
+func (s *MemoryDeviceStore) RemoveDevice(user, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.devices[user][id]; !ok {
+		return ErrorDeviceNotFound
+	}
+
+	delete(s.devices[user], id)
+	return nil
+}
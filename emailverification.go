@@ -0,0 +1,177 @@
+package goauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labstack/echo"
+)
+
+type emailVerification struct {
+	key     []byte
+	ttl     time.Duration
+	confirm func(user, email string) error
+}
+
+type emailToken struct {
+	User    string `json:"sub"`
+	Email   string `json:"email"`
+	Expires int64  `json:"exp"`
+}
+
+// EmailVerification enables email verification tokens, which are signed with
+// key and valid for ttl (24 hours by default). Once a token is verified, the
+// confirm function is called with the user ID and the verified email, e.g. to
+// set Fields.EmailVerified and store the new address.
+func EmailVerification(key []byte, ttl time.Duration, confirm func(user, email string) error) AuthenticatorOption {
+	return func(auth *authenticator) {
+		if len(key) < 32 {
+			panic("The email verification key must be at least 32 bytes long")
+		}
+
+		if confirm == nil {
+			panic("The email verification confirm function cannot be nil")
+		}
+
+		if ttl <= 0 {
+			ttl = 24 * time.Hour
+		}
+
+		auth.emailVerification = &emailVerification{
+			key:     key,
+			ttl:     ttl,
+			confirm: confirm,
+		}
+	}
+}
+
+// EmailVerificationToken returns a token verifying the email of the user, e.g.
+// to be sent as link on sign up or when the email changes
+func (auth *authenticator) EmailVerificationToken(user, email string) (string, error) {
+	ev := auth.emailVerification
+	if ev == nil {
+		return "", ErrorEmailVerificationDisabled
+	}
+
+	if user == "" || email == "" {
+		return "", ErrorEmailTokenInvalid
+	}
+
+	b, err := json.Marshal(emailToken{
+		User:    user,
+		Email:   email,
+		Expires: time.Now().Add(ev.ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + ev.sign(payload), nil
+}
+
+// VerifyEmail verifies the token and confirms the email of the user via the
+// confirm function. It returns the user ID and the verified email.
+func (auth *authenticator) VerifyEmail(token string) (string, string, error) {
+	ev := auth.emailVerification
+	if ev == nil {
+		return "", "", ErrorEmailVerificationDisabled
+	}
+
+	t, err := ev.verify(token)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = ev.confirm(t.User, t.Email)
+	if err != nil {
+		return "", "", err
+	}
+
+	return t.User, t.Email, nil
+}
+
+// EmailVerificationHandler provides a net/http handler verifying the token of
+// the "token" query parameter
+func (auth *authenticator) EmailVerificationHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code, body := auth.verifyEmailResponse(r.URL.Query().Get("token"))
+		auth.json(w, code, body)
+	})
+}
+
+// EchoEmailVerificationHandler provides an email verification handler for the
+// echo framework
+func (auth *authenticator) EchoEmailVerificationHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.JSON(auth.verifyEmailResponse(c.QueryParam("token")))
+	}
+}
+
+// GinEmailVerificationHandler provides an email verification handler for the
+// gin framework
+func (auth *authenticator) GinEmailVerificationHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(auth.verifyEmailResponse(c.Query("token")))
+	}
+}
+
+func (auth *authenticator) verifyEmailResponse(token string) (int, map[string]interface{}) {
+	_, email, err := auth.VerifyEmail(token)
+	switch err {
+	case nil:
+		return http.StatusOK, StatusEmailVerified(email)
+	case ErrorEmailVerificationDisabled:
+		return http.StatusNotFound, StatusError(http.StatusNotFound, err)
+	case ErrorEmailTokenInvalid:
+		return http.StatusBadRequest, StatusError(http.StatusBadRequest, err)
+	default:
+		return http.StatusInternalServerError, StatusError(http.StatusInternalServerError, err)
+	}
+}
+
+func (ev *emailVerification) sign(payload string) string {
+	mac := hmac.New(sha256.New, ev.key)
+	mac.Write([]byte("email-verification"))
+	mac.Write([]byte{0})
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the signature and expiry of the token and returns its payload
+func (ev *emailVerification) verify(token string) (emailToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(ev.sign(parts[0]))) {
+		return emailToken{}, ErrorEmailTokenInvalid
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return emailToken{}, ErrorEmailTokenInvalid
+	}
+
+	var t emailToken
+	err = json.Unmarshal(b, &t)
+	if err != nil || t.User == "" || t.Email == "" || time.Now().Unix() > t.Expires {
+		return emailToken{}, ErrorEmailTokenInvalid
+	}
+
+	return t, nil
+}
+
+// emailVerified returns the field of the user tagged with
+// goauth:"email_verified" or the claim of the token the context was created from
+func (c *context) emailVerified() (bool, bool) {
+	if v, ok := c.twoFAMap["email_verified"].(bool); ok {
+		return v, true
+	}
+
+	v, ok := c.claims["email_verified"].(bool)
+	return v, ok
+}
@@ -0,0 +1,151 @@
+package goauth
+
+import (
+	"sync"
+	"time"
+)
+
+type enrollment struct {
+	ttl         time.Duration
+	maxAttempts int
+	confirm     func(user, method, secret string) error
+	key         []byte
+
+	mu      sync.Mutex
+	pending map[string]pendingEnrollment
+}
+
+type pendingEnrollment struct {
+	method   string
+	secret   []byte
+	expires  time.Time
+	attempts int
+}
+
+// TwoFAEnrollment enables two-step 2FA enrollment. Secrets registered with
+// Context.RegisterTwoFA or Context.EnrollTwoFA are held encrypted until the user
+// submits a valid first code via Context.ConfirmTwoFA. Only then the secret is
+// persisted via the confirm function. Abandoned enrollments expire after ttl.
+func TwoFAEnrollment(ttl time.Duration, confirm func(user, method, secret string) error) AuthenticatorOption {
+	return func(auth *authenticator) {
+		if confirm == nil {
+			panic("Enrollment confirm function cannot be nil")
+		}
+
+		if ttl <= 0 {
+			ttl = 10 * time.Minute
+		}
+
+		// Pending enrollments only live in memory, so a key per process suffices
+		key, err := randomCryptoBytes(32)
+		if err != nil {
+			panic(err)
+		}
+
+		auth.enrollment = &enrollment{
+			ttl:         ttl,
+			maxAttempts: 5,
+			confirm:     confirm,
+			key:         key,
+			pending:     make(map[string]pendingEnrollment),
+		}
+	}
+}
+
+// hold stores the secret of the user until the enrollment is confirmed. A
+// previous pending enrollment of the user is replaced.
+func (e *enrollment) hold(user, method, secret string) error {
+	sealed, err := seal(e.key, []byte(secret), []byte(user))
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	for k, p := range e.pending {
+		if now.After(p.expires) {
+			delete(e.pending, k)
+		}
+	}
+
+	e.pending[user] = pendingEnrollment{
+		method:  method,
+		secret:  sealed,
+		expires: now.Add(e.ttl),
+	}
+
+	return nil
+}
+
+// complete validates the code against the pending secret of the user and
+// persists the secret via the confirm function. The secret is sealed if a
+// keyring is provided. It returns the method and the stored secret of the
+// completed enrollment.
+func (e *enrollment) complete(user, code string, method func(string) TwoFAMethod, k *Keyring) (string, string, error) {
+	e.mu.Lock()
+	p, ok := e.pending[user]
+	if !ok || time.Now().After(p.expires) {
+		delete(e.pending, user)
+		e.mu.Unlock()
+		return "", "", ErrorEnrollmentNotFound
+	}
+
+	// Remove the enrollment while validating, so a code can't be used twice
+	delete(e.pending, user)
+	e.mu.Unlock()
+
+	secret, err := open(e.key, p.secret, []byte(user))
+	if err != nil {
+		return "", "", err
+	}
+
+	m := method(p.method)
+	if m == nil {
+		return "", "", ErrorEnrollmentNotFound
+	}
+
+	var valid bool
+	if c, ok := m.(TwoFAConfirmer); ok {
+		valid = c.ConfirmUser(user, code, string(secret))
+	} else if u, ok := m.(TwoFAUserMethod); ok {
+		valid = u.ValidateUser(user, code, string(secret))
+	} else {
+		valid = m.Validate(code, string(secret))
+	}
+
+	if !valid {
+		p.attempts++
+		if p.attempts < e.maxAttempts {
+			e.restore(user, p)
+		}
+		return "", "", Error2FAInvalidCode
+	}
+
+	stored := string(secret)
+	if k != nil {
+		stored, err = k.Seal(user, stored)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	err = e.confirm(user, p.method, stored)
+	if err != nil {
+		e.restore(user, p)
+		return "", "", err
+	}
+
+	return p.method, stored, nil
+}
+
+// restore puts the pending enrollment back, unless it was replaced meanwhile
+func (e *enrollment) restore(user string, p pendingEnrollment) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.pending[user]; !ok {
+		e.pending[user] = p
+	}
+}
@@ -10,6 +10,92 @@ var (
 	ErrorEmptyKey             = errors.New("The key / token cannot be empty")
 	Error2FAInavlidSecretSize = errors.New("The 2FA secret size must be > 0")
 	Error2FANotValidated      = errors.New("The user uses 2FA and no code was validated")
+	Error2FAResyncFailed      = errors.New("The codes could not be resynchronized")
+	ErrorInvalidToken         = errors.New("The token is invalid")
+	ErrorInvalidRequest       = errors.New("The request is invalid")
+
+	ErrorRecoveryCodesDisabled = errors.New("Recovery codes are not enabled")
+
+	ErrorTokenExchangeDisabled = errors.New("Token exchange is not enabled")
+	ErrorUnsupportedTokenType  = errors.New("Unsupported token type")
+	ErrorInvalidSubjectToken   = errors.New("The subject token is invalid")
+	ErrorInvalidActorToken     = errors.New("The actor token is invalid")
+	ErrorActorNotAllowed       = errors.New("The actor is not allowed to act on behalf of the subject")
+	ErrorInvalidScope          = errors.New("The requested scope exceeds the scope of the subject token")
+	ErrorInvalidTarget         = errors.New("The requested audience exceeds the audience of the subject token")
+
+	ErrorMalformedCBOR            = errors.New("Malformed CBOR data")
+	ErrorUnsupportedCOSEKey       = errors.New("Unsupported COSE key")
+	ErrorCredentialNotFound       = errors.New("The credential was not found")
+	ErrorCredentialExists         = errors.New("The credential is already registered")
+	ErrorPasskeysDisabled         = errors.New("Passkeys are not enabled")
+	ErrorWebauthnUserHandle       = errors.New("The user handle must be between 1 and 64 bytes")
+	ErrorWebauthnResponse         = errors.New("Malformed WebAuthn response")
+	ErrorWebauthnChallenge        = errors.New("Unknown or expired WebAuthn challenge")
+	ErrorWebauthnOrigin           = errors.New("Invalid WebAuthn origin")
+	ErrorWebauthnRelyingParty     = errors.New("Invalid WebAuthn relying party")
+	ErrorWebauthnUserPresence     = errors.New("The user was not present")
+	ErrorWebauthnUserVerification = errors.New("The user was not verified")
+	ErrorWebauthnAttestation      = errors.New("Invalid WebAuthn attestation")
+	ErrorWebauthnSignature        = errors.New("Invalid WebAuthn signature")
+	ErrorWebauthnSignCount        = errors.New("The signature counter did not increase, the authenticator may be cloned")
+
+	ErrorQRCodeLevel = errors.New("Unsupported QR code error correction level, use L, M, Q or H")
+
+	ErrorDecryptionFailed   = errors.New("The data could not be decrypted")
+	ErrorEnrollmentDisabled = errors.New("Two-step 2FA enrollment is not enabled")
+	ErrorEnrollmentNotFound = errors.New("No pending 2FA enrollment found, it may have expired")
+	Error2FAInvalidCode     = errors.New("The 2FA code is invalid")
+
+	ErrorKeyringVersion = errors.New("Unknown keyring key version")
+	ErrorKeyringKeySize = errors.New("Keyring keys must be 32 bytes long")
+
+	Error2FAMethodMissing = errors.New("The user has no 2FA method")
+	Error2FAMethodUnknown = errors.New("The 2FA method of the user is not registered")
+	Error2FASecretMissing = errors.New("The user has no 2FA secret")
+	Error2FAUserMissing   = errors.New("The user has no 2FA user")
+
+	Error2FATooManyAttempts = errors.New("Too many invalid 2FA codes, try again later")
+
+	ErrorTrustedDevicesDisabled = errors.New("Trusted devices are not enabled")
+	ErrorDeviceNotFound         = errors.New("The device was not found")
+
+	ErrorPushRequestNotFound = errors.New("The push approval request was not found")
+	ErrorPushRequestDecided  = errors.New("The push approval request was already decided or expired")
+	ErrorPushNumberMismatch  = errors.New("The number does not match the login, the request was denied")
+
+	ErrorAuthTooOld     = errors.New("The authentication is too old, please authenticate again")
+	ErrorMissingFactors = errors.New("The authentication lacks required factors")
+	ErrorUserMismatch   = errors.New("The user doesn't match the user of the token")
+
+	ErrorPasswordHashFormat = errors.New("Unsupported or malformed password hash")
+	ErrorPasswordTooLong    = errors.New("The password is too long")
+	ErrorInvalidCredentials = errors.New("The credentials are invalid")
+
+	ErrorPasswordPolicyDisabled = errors.New("No password policy is configured")
+
+	ErrorAccountLocked                = errors.New("Too many failed login attempts, the account is locked")
+	ErrorBruteForceProtectionDisabled = errors.New("Brute-force protection is not enabled")
+
+	ErrorMagicLinksDisabled = errors.New("Magic links are not enabled")
+	ErrorMagicLinkInvalid   = errors.New("The magic link is invalid, expired or was already used")
+	ErrorMagicLinkBrowser   = errors.New("The magic link has to be opened in the browser it was requested in")
+	ErrorEmailMissing       = errors.New("The user has no email address")
+
+	ErrorPasswordResetDisabled = errors.New("Password reset is not enabled")
+	ErrorResetTokenInvalid     = errors.New("The password reset token is invalid, expired or was already used")
+	ErrorUserNotFound          = errors.New("The user was not found")
+	ErrorIdentifierMissing     = errors.New("The user has no field tagged with goauth:\"identifier\"")
+
+	ErrorEmailVerificationDisabled = errors.New("Email verification is not enabled")
+	ErrorEmailTokenInvalid         = errors.New("The email verification token is invalid or expired")
+	ErrorEmailNotVerified          = errors.New("The email address is not verified")
+
+	ErrorMissingRoles  = errors.New("The user lacks required roles")
+	ErrorMissingScopes = errors.New("The token lacks required scopes")
+
+	ErrorPolicyDenied     = errors.New("The request is denied by the policy")
+	ErrorResourceNotFound = errors.New("The resource was not found")
 )
 
 // ErrorKeyLookup returns an key lookup error in JSON to respond to HTTP request
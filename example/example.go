@@ -1,7 +1,6 @@
 package main
 
 import (
-	"errors"
 	"net/http"
 
 	"github.com/Techassi/goauth"
@@ -10,8 +9,8 @@ import (
 
 type User struct {
 	goauth.Fields
-	Username string
-	Password string
+	Username string `goauth:"identifier"`
+	Password string `json:",omitempty" goauth:"password"`
 }
 
 type App struct {
@@ -21,20 +20,6 @@ type App struct {
 var users []User
 
 func main() {
-	// Your user store, a database for exmaple
-	users = append(users, User{
-		Username: "Test",
-		Password: "Test",
-	}, User{
-		Username: "Test1",
-		Password: "Test1",
-	})
-
-	users[0].UsesTwoFA = false
-	users[0].TwoFAMethod = "totp"
-	users[0].TwoFASecret = "secret"
-	users[0].TwoFAUser = "test@test.de"
-
 	// The authenticator
 	g := goauth.New(
 		// Here you define your user lookup function
@@ -47,6 +32,24 @@ func main() {
 		goauth.Redirect("/error"),
 	)
 
+	// Your user store, a database for exmaple. Only password hashes are stored.
+	for _, name := range []string{"Test", "Test1"} {
+		hash, err := g.HashPassword(name)
+		if err != nil {
+			panic(err)
+		}
+
+		users = append(users, User{
+			Username: name,
+			Password: hash,
+		})
+	}
+
+	users[0].UsesTwoFA = false
+	users[0].TwoFAMethod = "totp"
+	users[0].TwoFASecret = "secret"
+	users[0].TwoFAUser = "test@test.de"
+
 	// Your app
 	app := &App{
 		Auth: g,
@@ -73,7 +76,11 @@ func (a *App) Login(c echo.Context) error {
 		Password: "Test",
 	}, c.Request())
 
-	// If there was an error identifying the user, return this error
+	// If the credentials are wrong or there was an error identifying the user,
+	// return this error
+	if err == goauth.ErrorInvalidCredentials {
+		return c.JSON(http.StatusUnauthorized, Unauthorized(err))
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, Error(err))
 	}
@@ -142,11 +149,13 @@ func Error(err error) map[string]interface{} {
 //////////////////////////////////// LOOKUP FUNCTION /////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////////
 
+// lookupFunction returns the stored user, goauth verifies the password against
+// the stored hash
 func lookupFunction(m map[string]interface{}) (interface{}, error) {
 	for _, u := range users {
-		if u.Username == m["Username"] && u.Password == m["Password"] {
+		if u.Username == m["Username"] {
 			return u, nil
 		}
 	}
-	return nil, errors.New("User not found")
+	return nil, nil
 }
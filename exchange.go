@@ -0,0 +1,358 @@
+package goauth
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// GrantTypeTokenExchange is the grant type of RFC 8693 token exchange requests
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+	// TokenTypeAccessToken indicates an OAuth 2.0 access token
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+
+	// TokenTypeJWT indicates a JWT
+	TokenTypeJWT = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// TokenExchangeRequest describes a RFC 8693 token exchange request
+type TokenExchangeRequest struct {
+	// SubjectToken represents the identity of the party on behalf of whom the
+	// request is being made.
+	// Required.
+	SubjectToken     string
+	SubjectTokenType string
+
+	// ActorToken represents the identity of the acting party. If empty, the
+	// subject token is only exchanged for a downscoped one (delegation without
+	// an actor).
+	ActorToken     string
+	ActorTokenType string
+
+	// RequestedTokenType defaults to TokenTypeAccessToken
+	RequestedTokenType string
+
+	// Audience restricts the new token to the provided audiences
+	Audience []string
+
+	// Scope restricts the new token to the provided scopes. Only scopes granted
+	// by the subject token are issued.
+	Scope []string
+}
+
+// TokenExchangeResponse is the RFC 8693 token exchange response
+type TokenExchangeResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in,omitempty"`
+	Scope           string `json:"scope,omitempty"`
+}
+
+// TokenExchangePolicy decides if the actor is allowed to act on behalf of the
+// subject. The actor claims are nil if no actor token was provided.
+type TokenExchangePolicy func(subject, actor map[string]interface{}, req TokenExchangeRequest) error
+
+type tokenExchange struct {
+	lifetime time.Duration
+	policy   TokenExchangePolicy
+}
+
+// TokenExchange enables RFC 8693 token exchange. Tokens issued by the exchange
+// are valid for at most lifetime and never outlive the subject token. If no
+// policy is provided, an actor is only allowed to act on behalf of the subject
+// if the subject token carries a matching "may_act" claim.
+func TokenExchange(lifetime time.Duration, policy TokenExchangePolicy) AuthenticatorOption {
+	return func(auth *authenticator) {
+		if lifetime <= 0 {
+			lifetime = 15 * time.Minute
+		}
+
+		auth.exchange = &tokenExchange{
+			lifetime: lifetime,
+			policy:   policy,
+		}
+	}
+}
+
+// ExchangeToken validates the subject and optional actor token and issues a new
+// token with reduced scopes / audience and an "act" claim identifying the actor
+func (auth *authenticator) ExchangeToken(req TokenExchangeRequest) (TokenExchangeResponse, error) {
+	if auth.exchange == nil {
+		return TokenExchangeResponse{}, ErrorTokenExchangeDisabled
+	}
+
+	if !supportedTokenType(req.SubjectTokenType) || !supportedTokenType(req.ActorTokenType) {
+		return TokenExchangeResponse{}, ErrorUnsupportedTokenType
+	}
+
+	issuedType := req.RequestedTokenType
+	if issuedType == "" {
+		issuedType = TokenTypeAccessToken
+	}
+	if !supportedTokenType(issuedType) {
+		return TokenExchangeResponse{}, ErrorUnsupportedTokenType
+	}
+
+	subject, err := auth.validClaims(req.SubjectToken)
+	if err != nil {
+		return TokenExchangeResponse{}, ErrorInvalidSubjectToken
+	}
+
+	var actor jwt.MapClaims
+	if req.ActorToken != "" {
+		actor, err = auth.validClaims(req.ActorToken)
+		if err != nil {
+			return TokenExchangeResponse{}, ErrorInvalidActorToken
+		}
+	}
+
+	if auth.exchange.policy != nil {
+		err = auth.exchange.policy(subject, actor, req)
+	} else if actor != nil && !mayAct(subject, actor) {
+		err = ErrorActorNotAllowed
+	}
+	if err != nil {
+		return TokenExchangeResponse{}, err
+	}
+
+	scope, err := exchangeScope(subject, req.Scope)
+	if err != nil {
+		return TokenExchangeResponse{}, err
+	}
+
+	audience, err := exchangeAudience(subject, req.Audience)
+	if err != nil {
+		return TokenExchangeResponse{}, err
+	}
+
+	claims := make(map[string]interface{})
+	for k, v := range subject {
+		switch k {
+		case "exp", "iat", "nbf", "jti", "aud", "scope", "may_act", "amr", "auth_time":
+			continue
+		}
+		claims[k] = v
+	}
+
+	// The step-up state is the one of whoever uses the token, so actors don't
+	// inherit the authentication of the subject
+	authn := subject
+	if actor != nil {
+		authn = actor
+	}
+	for _, k := range []string{"amr", "auth_time"} {
+		if v, ok := authn[k]; ok {
+			claims[k] = v
+		}
+	}
+
+	now := time.Now()
+	exp := now.Add(auth.exchange.lifetime).Unix()
+	if e, ok := subject["exp"].(float64); ok && int64(e) < exp {
+		exp = int64(e)
+	}
+
+	claims["iat"] = now.Unix()
+	claims["exp"] = exp
+
+	if len(scope) > 0 {
+		claims["scope"] = strings.Join(scope, " ")
+	}
+
+	switch len(audience) {
+	case 0:
+	case 1:
+		claims["aud"] = audience[0]
+	default:
+		claims["aud"] = audience
+	}
+
+	if actor != nil {
+		act := actorIdentity(actor)
+		if prior, ok := subject["act"]; ok {
+			act["act"] = prior
+		}
+		claims["act"] = act
+	}
+
+	token, err := auth.authMethod.Create(claims)
+	if err != nil {
+		return TokenExchangeResponse{}, err
+	}
+
+	return TokenExchangeResponse{
+		AccessToken:     token,
+		IssuedTokenType: issuedType,
+		TokenType:       "Bearer",
+		ExpiresIn:       exp - now.Unix(),
+		Scope:           strings.Join(scope, " "),
+	}, nil
+}
+
+// TokenExchangeHandler provides a RFC 8693 token exchange endpoint
+func (auth *authenticator) TokenExchangeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			auth.json(w, http.StatusMethodNotAllowed, StatusTokenExchangeError("invalid_request", ErrorInvalidRequest))
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			auth.json(w, http.StatusBadRequest, StatusTokenExchangeError("invalid_request", err))
+			return
+		}
+
+		if r.PostForm.Get("grant_type") != GrantTypeTokenExchange {
+			auth.json(w, http.StatusBadRequest, StatusTokenExchangeError("unsupported_grant_type", ErrorInvalidRequest))
+			return
+		}
+
+		req := TokenExchangeRequest{
+			SubjectToken:       r.PostForm.Get("subject_token"),
+			SubjectTokenType:   r.PostForm.Get("subject_token_type"),
+			ActorToken:         r.PostForm.Get("actor_token"),
+			ActorTokenType:     r.PostForm.Get("actor_token_type"),
+			RequestedTokenType: r.PostForm.Get("requested_token_type"),
+			Audience:           r.PostForm["audience"],
+			Scope:              strings.Fields(r.PostForm.Get("scope")),
+		}
+
+		if req.SubjectToken == "" || req.SubjectTokenType == "" ||
+			(req.ActorToken == "") != (req.ActorTokenType == "") {
+			auth.json(w, http.StatusBadRequest, StatusTokenExchangeError("invalid_request", ErrorInvalidRequest))
+			return
+		}
+
+		res, err := auth.ExchangeToken(req)
+		switch err {
+		case nil:
+		case ErrorTokenExchangeDisabled:
+			auth.json(w, http.StatusNotFound, StatusTokenExchangeError("invalid_request", err))
+			return
+		case ErrorInvalidScope:
+			auth.json(w, http.StatusBadRequest, StatusTokenExchangeError("invalid_scope", err))
+			return
+		case ErrorInvalidTarget:
+			auth.json(w, http.StatusBadRequest, StatusTokenExchangeError("invalid_target", err))
+			return
+		case ErrorUnsupportedTokenType:
+			auth.json(w, http.StatusBadRequest, StatusTokenExchangeError("invalid_request", err))
+			return
+		default:
+			auth.json(w, http.StatusBadRequest, StatusTokenExchangeError("invalid_grant", err))
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		auth.json(w, http.StatusOK, res)
+	})
+}
+
+func supportedTokenType(t string) bool {
+	return t == "" || t == TokenTypeAccessToken || t == TokenTypeJWT
+}
+
+// mayAct checks the "may_act" claim of the subject against the actor
+func mayAct(subject, actor map[string]interface{}) bool {
+	m, ok := subject["may_act"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+
+	sub, ok := m["sub"].(string)
+	return ok && sub != "" && sub == actor["sub"]
+}
+
+// actorIdentity extracts the claims identifying the actor for the "act" claim
+func actorIdentity(actor map[string]interface{}) map[string]interface{} {
+	act := make(map[string]interface{})
+	for _, k := range []string{"sub", "iss"} {
+		if v, ok := actor[k]; ok {
+			act[k] = v
+		}
+	}
+
+	if _, ok := act["sub"]; !ok {
+		if u, ok := actor["user"]; ok {
+			act["user"] = u
+		}
+	}
+
+	return act
+}
+
+// exchangeScope returns the scopes of the exchanged token, the requested scopes
+// the subject token has. Subject tokens without scopes grant none.
+func exchangeScope(subject map[string]interface{}, requested []string) ([]string, error) {
+	granted := claimValues(subject, "scope")
+	if len(requested) == 0 {
+		return granted, nil
+	}
+
+	scope := make([]string, 0, len(requested))
+	for _, r := range requested {
+		if containsString(granted, r) && !containsString(scope, r) {
+			scope = append(scope, r)
+		}
+	}
+
+	if len(scope) == 0 {
+		return nil, ErrorInvalidScope
+	}
+
+	return scope, nil
+}
+
+// exchangeAudience returns the audience of the exchanged token. If the subject
+// token is restricted to audiences, the requested audiences have to be a subset.
+func exchangeAudience(subject map[string]interface{}, requested []string) ([]string, error) {
+	var granted []string
+	switch aud := subject["aud"].(type) {
+	case string:
+		granted = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				granted = append(granted, s)
+			}
+		}
+	default:
+		return requested, nil
+	}
+
+	if len(requested) == 0 {
+		return granted, nil
+	}
+
+	for _, r := range requested {
+		if !containsString(granted, r) {
+			return nil, ErrorInvalidTarget
+		}
+	}
+
+	return requested, nil
+}
+
+// actorChain flattens the nested "act" claims, starting with the current actor
+func actorChain(claims map[string]interface{}) []map[string]interface{} {
+	chain := make([]map[string]interface{}, 0)
+	act, ok := claims["act"].(map[string]interface{})
+	for ok {
+		a := make(map[string]interface{})
+		for k, v := range act {
+			if k != "act" {
+				a[k] = v
+			}
+		}
+		chain = append(chain, a)
+		act, ok = act["act"].(map[string]interface{})
+	}
+
+	return chain
+}
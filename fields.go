@@ -13,4 +13,7 @@ type Fields struct {
 
 	// TwoFAUser indicates which user information is used (typically the users email)
 	TwoFAUser string `json:"-" goauth:"twofa_user"`
+
+	// EmailVerified indicates if the user verified their email address
+	EmailVerified bool `json:"email_verified" goauth:"email_verified"`
 }
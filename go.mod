@@ -3,6 +3,7 @@ module github.com/Techassi/goauth
 go 1.13
 
 require (
+	github.com/boombuler/barcode v1.0.1-0.20190219062509-6c824513bacc
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/gin-gonic/gin v1.6.2
 	github.com/gorilla/sessions v1.2.0
@@ -10,6 +11,6 @@ require (
 	github.com/labstack/gommon v0.3.0 // indirect
 	github.com/pquerna/otp v1.2.0
 	github.com/stretchr/testify v1.5.1 // indirect
-	golang.org/x/crypto v0.0.0-20200420201142-3c4aac89819a // indirect
+	golang.org/x/crypto v0.0.0-20200420201142-3c4aac89819a
 	gopkg.in/square/go-jose.v2 v2.5.1
 )
@@ -7,8 +7,10 @@ Package goauth
 package goauth
 
 import (
+	"fmt"
 	"net/http"
 	"sync"
+	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
@@ -22,29 +24,86 @@ type (
 	// Authenticator is the top level Authenticator interface
 	Authenticator interface {
 		Identify(interface{}, *http.Request) (Context, error)
+		Reauthenticate(interface{}, *http.Request) (Context, error)
 		Middleware(next http.Handler) http.Handler
-		EchoMiddleware() echo.MiddlewareFunc
-		GinMiddleware() gin.HandlerFunc
+		MiddlewareWith(...MiddlewareOption) func(http.Handler) http.Handler
+		EchoMiddleware(...MiddlewareOption) echo.MiddlewareFunc
+		GinMiddleware(...MiddlewareOption) gin.HandlerFunc
 		AuthMethod() AuthenticationMethod
 		TwoFAMethod(string) TwoFAMethod
 		TwoFAMethods() map[string]TwoFAMethod
+		ExchangeToken(TokenExchangeRequest) (TokenExchangeResponse, error)
+		TokenExchangeHandler() http.Handler
+		BeginPasskeyLogin() (*CredentialRequestOptions, error)
+		IdentifyPasskey([]byte) (Context, error)
+		TrustedDevices(string) ([]TrustedDevice, error)
+		RevokeDevice(string, string) error
+		RevokeDevices(string) error
+		HashPassword(string) (string, error)
+		VerifyPassword(interface{}, string, string) (bool, error)
+		CheckPassword(string, ...string) ([]PasswordViolation, error)
+		Unlock(string) error
+		UnlockIP(string) error
+		RateLimit(int, time.Duration, ...RateLimitOption) func(http.Handler) http.Handler
+		EchoRateLimit(int, time.Duration, ...RateLimitOption) echo.MiddlewareFunc
+		GinRateLimit(int, time.Duration, ...RateLimitOption) gin.HandlerFunc
+		SendMagicLink(interface{}, *http.Request) (*http.Cookie, error)
+		IdentifyMagicLink(*http.Request) (Context, error)
+		CreatePasswordReset(interface{}) (string, error)
+		VerifyPasswordReset(string) (interface{}, error)
+		ResetPassword(string, string) (interface{}, string, error)
+		EmailVerificationToken(string, string) (string, error)
+		VerifyEmail(string) (string, string, error)
+		EmailVerificationHandler() http.Handler
+		EchoEmailVerificationHandler() echo.HandlerFunc
+		GinEmailVerificationHandler() gin.HandlerFunc
+		Authorize(*Policy, *http.Request, map[string]string, map[string]interface{}) (Decision, error)
+		PolicyMiddleware(*Policy, ParamFunc, ResourceFunc) func(http.Handler) http.Handler
+		EchoPolicyMiddleware(*Policy, ResourceFunc) echo.MiddlewareFunc
+		GinPolicyMiddleware(*Policy, ResourceFunc) gin.HandlerFunc
 	}
 
 	// authenticator is the internal struct
 	authenticator struct {
-		lookupMethod   LookupMethod
-		twoFaMethods   map[string]TwoFAMethod
-		authMethod     AuthenticationMethod
-		redirect       bool
-		redirectTarget string
-		pool           sync.Pool
+		lookupMethod      LookupMethod
+		lookupVerifies    bool
+		twoFaMethods      map[string]TwoFAMethod
+		authMethod        AuthenticationMethod
+		redirect          bool
+		redirectTarget    string
+		exchange          *tokenExchange
+		passkeys          func([]byte) (interface{}, error)
+		recovery          *recovery
+		enrollment        *enrollment
+		keyring           *Keyring
+		pending           *pendingLogins
+		twoFAAttempts     *twoFAAttempts
+		devices           *devices
+		hasher            PasswordHasher
+		rehash            func(interface{}, string) error
+		rehashError       func(interface{}, error)
+		dummy             dummyPassword
+		policy            *passwordPolicy
+		lockout           *lockout
+		magicLinks        *magicLinks
+		passwordReset     *passwordReset
+		emailVerification *emailVerification
+		rolesClaim        string
+		scopesClaim       string
+		pool              sync.Pool
 	}
 )
 
 // New will create a new Authenticator with the provided AuthenticatorOption(s)
 func New(options ...AuthenticatorOption) Authenticator {
 	auth := &authenticator{
-		twoFaMethods: make(map[string]TwoFAMethod),
+		twoFaMethods:  make(map[string]TwoFAMethod),
+		pending:       newPendingLogins(),
+		twoFAAttempts: newTwoFAAttempts(),
+		hasher:        DefaultArgon2id(),
+		rehashError:   logRehashError,
+		rolesClaim:    "roles",
+		scopesClaim:   "scope",
 	}
 	auth.pool.New = func() interface{} {
 		return auth.newContext(nil)
@@ -63,28 +122,239 @@ func (auth *authenticator) Identify(user interface{}, r *http.Request) (Context,
 	t, err := auth.authMethod.Lookup(r)
 	token, err := auth.authMethod.Validate(t)
 	if token.Valid && err == nil {
-		ctx := auth.newContext(token.Claims.(jwt.MapClaims))
-		ctx.SetAuthenticated()
-		return ctx, nil
+		claims := token.Claims.(jwt.MapClaims)
+
+		// The user passed the first factor and has to validate 2FA
+		if pending, _ := claims["twofa_pending"].(bool); pending {
+			if ctx, ok := auth.pendingContext(claims); ok {
+				return ctx, nil
+			}
+		} else {
+			ctx := auth.newContext(claims)
+			ctx.claims = claims
+			ctx.amr = claimsAMR(claims)
+			ctx.authTime = claimsAuthTime(claims)
+			ctx.SetAuthenticated()
+			return ctx, nil
+		}
+	}
+
+	stored, err := auth.checkCredentials(user, r)
+	if err != nil {
+		return nil, err
+	}
+
+	return auth.userContext(stored, r, "pwd")
+}
+
+// Reauthenticate verifies the user of the token of the request again, e.g. to
+// satisfy a step-up challenge. The user submits their password, a 2FA code or
+// both. Without password, the user has to use 2FA and the code has to be
+// validated via Context.ValidateTwoFA. Trusted devices don't skip 2FA. The token
+// created by Context.Authenticate records a fresh "auth_time" and only the
+// factors verified during the re-authentication.
+func (auth *authenticator) Reauthenticate(user interface{}, r *http.Request) (Context, error) {
+	t, err := auth.lookupToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := auth.validClaims(t)
+	if err != nil {
+		return nil, err
+	}
+
+	password, _ := getTags(user)["password"].(string)
+
+	var stored interface{}
+	if password != "" {
+		stored, err = auth.checkCredentials(user, r)
+	} else {
+		stored, err = auth.lookupUser(user)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = sameUser(claims, stored)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, err := auth.userContext(stored, nil, "pwd")
+	if err != nil {
+		return nil, err
+	}
+
+	c := ctx.(*context)
+	c.amr = nil
+	if password != "" {
+		c.amr = appendFactor(c.amr, "pwd")
+	} else if !c.UsesTwoFA() {
+		return nil, ErrorInvalidCredentials
+	}
+
+	return c, nil
+}
+
+// checkCredentials looks up the stored user of the submitted user and verifies
+// the submitted password. Failed attempts count towards the lockout.
+func (auth *authenticator) checkCredentials(user interface{}, r *http.Request) (interface{}, error) {
+	// Convert user interface to map, the submitted password isn't passed to the
+	// lookup function
+	submitted := getTags(user)
+	password, _ := submitted["password"].(string)
+	m, err := userMap(user)
+	if err != nil {
+		return nil, err
+	}
+
+	identifier := ""
+	if id, ok := submitted["identifier"]; ok && id != nil {
+		identifier = fmt.Sprint(id)
+	}
+
+	ip := clientIP(r)
+	if auth.lockout != nil {
+		err = auth.lockout.take(identifier, ip)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// User is not authenticated, so lookup the stored user and verify the
+	// credentials
+	stored, ok, err := auth.lookupCredentials(m, password)
+	if auth.lockout != nil {
+		switch {
+		case err != nil:
+			auth.lockout.release(identifier, ip)
+		case ok:
+			err = auth.lockout.succeed(identifier, ip)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, ErrorInvalidCredentials
+	}
+
+	return stored, nil
+}
+
+// lookupCredentials looks up the stored user and verifies the password
+func (auth *authenticator) lookupCredentials(m map[string]interface{}, password string) (interface{}, bool, error) {
+	stored, err := auth.lookupMethod.Do(m)
+	if err != nil {
+		return nil, false, err
+	}
+
+	ok, err := auth.verifyCredentials(stored, password)
+	return stored, ok, err
+}
+
+// lookupUser looks up the stored user of the submitted user
+func (auth *authenticator) lookupUser(user interface{}) (interface{}, error) {
+	m, err := userMap(user)
+	if err != nil {
+		return nil, err
 	}
 
-	// Convert user interface to map
-	var m map[string]interface{}
-	err = interfaceToMap(user, &m)
+	stored, err := auth.lookupMethod.Do(m)
 	if err != nil {
 		return nil, err
 	}
 
-	// User is not authenticated, so lookup user and create context
-	user, err = auth.lookupMethod.Do(m)
+	if isNil(stored) {
+		return nil, ErrorUserNotFound
+	}
+	return stored, nil
+}
+
+// sameUser checks that the stored user is the user of the token, compared by
+// the field tagged with goauth:"identifier"
+func sameUser(claims map[string]interface{}, stored interface{}) error {
+	_, lookup, err := identifierLookup(stored)
+	if err != nil {
+		return err
+	}
+
+	user, _ := claims["user"].(map[string]interface{})
+	for k, v := range lookup {
+		if c, ok := user[k]; !ok || fmt.Sprint(c) != fmt.Sprint(v) {
+			return ErrorUserMismatch
+		}
+	}
+	return nil
+}
+
+// userContext creates the context of the stored user, who passed the first
+// factor. The context is created from the stored user, never from the input.
+func (auth *authenticator) userContext(stored interface{}, r *http.Request, factor string) (Context, error) {
+	m, err := userMap(stored)
 	if err != nil {
 		return nil, err
 	}
 
 	ctx := auth.newContext(m)
+	ctx.twoFAMap = getTags(stored)
+
+	// 2FA is skipped on devices the user trusts
+	if auth.devices != nil && ctx.UsesTwoFA() {
+		account, _ := ctx.twoFAMap["twofa_user"].(string)
+		ctx.deviceExpires, ctx.twoFAValid = auth.devices.trusted(account, r)
+	}
+	ctx.amr = []string{factor}
 	return ctx, nil
 }
 
+// pendingContext restores the context of a login pending 2FA validation
+func (auth *authenticator) pendingContext(claims jwt.MapClaims) (*context, bool) {
+	id, _ := claims["jti"].(string)
+	l, ok := auth.pending.get(id)
+	if !ok {
+		return nil, false
+	}
+
+	ctx := auth.newContext(l.user)
+	ctx.twoFAMap = l.twoFAMap
+	ctx.amr = l.amr
+	ctx.pendingID = id
+	return ctx, true
+}
+
+// lookupToken returns the token of the request. A missing token cookie is not
+// an error, the empty token is rejected as unauthenticated by validClaims.
+func (auth *authenticator) lookupToken(r *http.Request) (string, error) {
+	t, err := auth.authMethod.Lookup(r)
+	if err == http.ErrNoCookie {
+		return "", nil
+	}
+	return t, err
+}
+
+// validClaims validates the token and returns its claims. Tokens of logins
+// pending 2FA validation are rejected.
+func (auth *authenticator) validClaims(key string) (jwt.MapClaims, error) {
+	token, err := auth.authMethod.Validate(key)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !token.Valid || !ok {
+		return nil, ErrorInvalidToken
+	}
+
+	if pending, _ := claims["twofa_pending"].(bool); pending {
+		return nil, Error2FANotValidated
+	}
+
+	return claims, nil
+}
+
 func (auth *authenticator) AuthMethod() AuthenticationMethod {
 	return auth.authMethod
 }
@@ -97,11 +367,10 @@ func (auth *authenticator) TwoFAMethod(key string) TwoFAMethod {
 	return auth.twoFaMethods[key]
 }
 
-// TODO: Extract user 2FA information - do this more cleanly
-func (auth *authenticator) newContext(usermap map[string]interface{}) Context {
+func (auth *authenticator) newContext(usermap map[string]interface{}) *context {
 	return &context{
 		user:          usermap,
+		twoFAMap:      make(map[string]interface{}),
 		authenticator: auth,
-		// twoFAMap:      getTags(user),
 	}
 }
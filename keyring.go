@@ -0,0 +1,175 @@
+package goauth
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+)
+
+// sealedPrefix marks secrets sealed by a Keyring, followed by the key version
+const sealedPrefix = "$aes256gcm$v"
+
+// keyringPurpose labels the additional data of sealed 2FA secrets
+const keyringPurpose = "goauth-2fa-secret"
+
+// Keyring holds versioned 256 bit key-encryption keys, which are used to seal 2FA
+// secrets before they are stored. New secrets are sealed with the current key,
+// older keys are kept to open secrets sealed before a rotation.
+type Keyring struct {
+	current uint32
+	keys    map[uint32][]byte
+}
+
+// NewKeyring creates a keyring. Secrets are sealed with the key of the current
+// version, which has to be part of keys. Each key must be 32 bytes long.
+func NewKeyring(current uint32, keys map[uint32][]byte) (*Keyring, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, ErrorKeyringVersion
+	}
+
+	k := &Keyring{
+		current: current,
+		keys:    make(map[uint32][]byte, len(keys)),
+	}
+
+	for v, key := range keys {
+		if len(key) != 32 {
+			return nil, ErrorKeyringKeySize
+		}
+		k.keys[v] = append([]byte(nil), key...)
+	}
+
+	return k, nil
+}
+
+// TwoFASecretKeyring seals the secrets passed to the confirm function of the
+// two-step enrollment and opens sealed 2FA secrets in Context.ValidateTwoFA
+func TwoFASecretKeyring(k *Keyring) AuthenticatorOption {
+	return func(auth *authenticator) {
+		auth.keyring = k
+	}
+}
+
+// Seal encrypts the secret of the user with AES-256-GCM under the current key.
+// The user is the 2FA user (goauth:"twofa_user") and is bound to the ciphertext,
+// so a sealed secret can't be swapped between users.
+func (k *Keyring) Seal(user, secret string) (string, error) {
+	sealed, err := seal(k.keys[k.current], []byte(secret), keyringAAD(user))
+	if err != nil {
+		return "", err
+	}
+
+	return sealedPrefix + strconv.FormatUint(uint64(k.current), 10) + "$" +
+		base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a sealed secret of the user with the key it was sealed with
+func (k *Keyring) Open(user, sealed string) (string, error) {
+	version, data, err := parseSealed(sealed)
+	if err != nil {
+		return "", err
+	}
+
+	key, ok := k.keys[version]
+	if !ok {
+		return "", ErrorKeyringVersion
+	}
+
+	secret, err := open(key, data, keyringAAD(user))
+	if err != nil {
+		return "", err
+	}
+
+	return string(secret), nil
+}
+
+// Rotate re-encrypts the secret of the user under the current key. Plaintext
+// secrets are sealed. It reports whether the secret changed.
+func (k *Keyring) Rotate(user, secret string) (string, bool, error) {
+	if IsSealed(secret) {
+		version, _, err := parseSealed(secret)
+		if err != nil {
+			return "", false, err
+		}
+
+		if version == k.current {
+			return secret, false, nil
+		}
+
+		secret, err = k.Open(user, secret)
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	sealed, err := k.Seal(user, secret)
+	if err != nil {
+		return "", false, err
+	}
+
+	return sealed, true, nil
+}
+
+// RotateSecrets re-encrypts the secrets of all users under the current key, e.g.
+// after adding a new key version. The list function returns the stored secrets
+// by 2FA user, the save function stores a re-encrypted secret. It returns the number
+// of re-encrypted secrets.
+func (k *Keyring) RotateSecrets(list func() (map[string]string, error), save func(user, sealed string) error) (int, error) {
+	secrets, err := list()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for user, secret := range secrets {
+		sealed, changed, err := k.Rotate(user, secret)
+		if err != nil {
+			return n, err
+		}
+
+		if !changed {
+			continue
+		}
+
+		err = save(user, sealed)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// keyringAAD returns the additional data binding a sealed secret to the user
+func keyringAAD(user string) []byte {
+	return []byte(keyringPurpose + "\x00" + user)
+}
+
+// IsSealed reports whether the secret was sealed by a Keyring
+func IsSealed(secret string) bool {
+	return strings.HasPrefix(secret, sealedPrefix)
+}
+
+func parseSealed(sealed string) (uint32, []byte, error) {
+	if !IsSealed(sealed) {
+		return 0, nil, ErrorDecryptionFailed
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(sealed, sealedPrefix), "$", 2)
+	if len(parts) != 2 {
+		return 0, nil, ErrorDecryptionFailed
+	}
+
+	version, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, nil, ErrorDecryptionFailed
+	}
+
+	data, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return 0, nil, ErrorDecryptionFailed
+	}
+
+	return uint32(version), data, nil
+}
@@ -0,0 +1,406 @@
+package goauth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Attempts are the failed login attempts of an account or client IP
+type Attempts struct {
+	// Failures since the last success or lockout
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+
+	// Lockouts is the number of consecutive lockouts, each doubles the duration
+	Lockouts    int       `json:"lockouts"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// AttemptStore stores failed login attempts, e.g. in Redis to share them
+// between instances
+type AttemptStore interface {
+	// Attempts returns the attempts of the key, the zero value if there are none
+	Attempts(key string) (Attempts, error)
+
+	// SaveAttempts stores the attempts of the key, they can be dropped after ttl
+	SaveAttempts(key string, a Attempts, ttl time.Duration) error
+
+	// ResetAttempts removes the attempts of the key
+	ResetAttempts(key string) error
+}
+
+// AccountLockedError is returned by Identify while the account or client IP is
+// locked. It wraps ErrorAccountLocked.
+type AccountLockedError struct {
+	Until time.Time
+}
+
+func (e *AccountLockedError) Error() string {
+	return fmt.Sprintf("%s until %s", ErrorAccountLocked.Error(), e.Until.UTC().Format(time.RFC3339))
+}
+
+// Unwrap returns ErrorAccountLocked
+func (e *AccountLockedError) Unwrap() error {
+	return ErrorAccountLocked
+}
+
+// LockoutOption represents a brute-force protection option
+type LockoutOption func(l *lockout)
+
+type lockout struct {
+	accountThreshold int
+	ipThreshold      int
+	window           time.Duration
+	lockDuration     time.Duration
+	maxLockDuration  time.Duration
+	delay            time.Duration
+	maxDelay         time.Duration
+	store            AttemptStore
+
+	mu sync.Mutex
+}
+
+// BruteForceProtection tracks failed logins in Identify per account, i.e. the
+// field tagged with goauth:"identifier", and per client IP. Failed attempts are
+// answered with a growing delay and after too many failures the account or IP
+// is locked. By default an account is locked after 5 and an IP after 20 failures
+// within 15 minutes, for 1 minute, doubling with every further lockout up to 24
+// hours.
+func BruteForceProtection(options ...LockoutOption) AuthenticatorOption {
+	return func(auth *authenticator) {
+		l := &lockout{
+			accountThreshold: 5,
+			ipThreshold:      20,
+			window:           15 * time.Minute,
+			lockDuration:     time.Minute,
+			maxLockDuration:  24 * time.Hour,
+			delay:            250 * time.Millisecond,
+			maxDelay:         4 * time.Second,
+		}
+
+		for _, f := range options {
+			f(l)
+		}
+
+		if l.store == nil {
+			l.store = NewMemoryAttemptStore()
+		}
+
+		auth.lockout = l
+		auth.twoFAAttempts.store = l.store
+	}
+}
+
+// LockoutThreshold sets the number of failures within the window which lock an
+// account or a client IP. A threshold of 0 disables the lockout.
+func LockoutThreshold(account, ip int, window time.Duration) LockoutOption {
+	return func(l *lockout) {
+		if account < 0 || ip < 0 || window <= 0 {
+			panic("Invalid lockout threshold")
+		}
+		l.accountThreshold = account
+		l.ipThreshold = ip
+		l.window = window
+	}
+}
+
+// LockoutDuration sets the duration of the first lockout, which doubles with
+// every further lockout up to max
+func LockoutDuration(base, max time.Duration) LockoutOption {
+	return func(l *lockout) {
+		if base <= 0 || max < base {
+			panic("Invalid lockout duration")
+		}
+		l.lockDuration = base
+		l.maxLockDuration = max
+	}
+}
+
+// LockoutDelay sets the delay after the first failure, which doubles with every
+// further failure up to max. A delay of 0 disables the delay.
+func LockoutDelay(base, max time.Duration) LockoutOption {
+	return func(l *lockout) {
+		if base < 0 || max < base {
+			panic("Invalid lockout delay")
+		}
+		l.delay = base
+		l.maxDelay = max
+	}
+}
+
+// LockoutStore sets the store of the failed attempts
+func LockoutStore(store AttemptStore) LockoutOption {
+	return func(l *lockout) {
+		l.store = store
+	}
+}
+
+// Unlock unlocks the account and resets its failed attempts and lockouts
+func (auth *authenticator) Unlock(identifier string) error {
+	if auth.lockout == nil {
+		return ErrorBruteForceProtectionDisabled
+	}
+
+	return auth.lockout.store.ResetAttempts(accountKey(identifier))
+}
+
+// UnlockIP unlocks the client IP and resets its failed attempts and lockouts
+func (auth *authenticator) UnlockIP(ip string) error {
+	if auth.lockout == nil {
+		return ErrorBruteForceProtectionDisabled
+	}
+
+	return auth.lockout.store.ResetAttempts(ipKey(ip))
+}
+
+// take returns an AccountLockedError if the account or IP is locked. Otherwise
+// the attempt is counted as failure until succeed or release is called and is
+// delayed according to the previous failures. Counting the attempt before the
+// password is verified keeps concurrent attempts from exceeding the threshold.
+func (l *lockout) take(identifier, ip string) error {
+	l.mu.Lock()
+
+	now := time.Now()
+	keys := l.keys(identifier, ip)
+	attempts := make([]Attempts, len(keys))
+	for i, key := range keys {
+		a, err := l.store.Attempts(key)
+		if err != nil {
+			l.mu.Unlock()
+			return err
+		}
+
+		if now.Before(a.LockedUntil) {
+			l.mu.Unlock()
+			return &AccountLockedError{Until: a.LockedUntil}
+		}
+
+		if now.Sub(a.LastFailure) > l.window {
+			a.Failures = 0
+		}
+		attempts[i] = a
+	}
+
+	// Lock once the threshold is reached, the attempt isn't verified anymore
+	for i, key := range keys {
+		a := attempts[i]
+		threshold := l.accountThreshold
+		if key == ipKey(ip) {
+			threshold = l.ipThreshold
+		}
+
+		if threshold > 0 && a.Failures >= threshold {
+			a.Failures = 0
+			a.Lockouts++
+			a.LockedUntil = now.Add(l.lockDurationFor(a.Lockouts))
+
+			err := l.store.SaveAttempts(key, a, l.ttl(a))
+			l.mu.Unlock()
+			if err != nil {
+				return err
+			}
+			return &AccountLockedError{Until: a.LockedUntil}
+		}
+	}
+
+	var delay time.Duration
+	for i, key := range keys {
+		a := attempts[i]
+		if d := l.delayFor(a.Failures); d > delay {
+			delay = d
+		}
+
+		a.Failures++
+		a.LastFailure = now
+		err := l.store.SaveAttempts(key, a, l.ttl(a))
+		if err != nil {
+			l.mu.Unlock()
+			return err
+		}
+	}
+
+	l.mu.Unlock()
+	time.Sleep(delay)
+	return nil
+}
+
+// succeed resets the failed attempts of the account and releases the attempt
+// of the IP. The previous attempts of the IP are kept, so attackers can't reset
+// them by logging into their own account.
+func (l *lockout) succeed(identifier, ip string) error {
+	if identifier != "" {
+		err := l.store.ResetAttempts(accountKey(identifier))
+		if err != nil {
+			return err
+		}
+	}
+
+	return l.release("", ip)
+}
+
+// release uncounts an attempt taken by take, e.g. if the credentials couldn't
+// be verified
+func (l *lockout) release(identifier, ip string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range l.keys(identifier, ip) {
+		a, err := l.store.Attempts(key)
+		if err != nil {
+			return err
+		}
+
+		if a.Failures == 0 {
+			continue
+		}
+
+		a.Failures--
+		err = l.store.SaveAttempts(key, a, l.ttl(a))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ttl returns how long the attempts have to be kept. The lockouts are kept to
+// double the next lockout.
+func (l *lockout) ttl(a Attempts) time.Duration {
+	if a.Lockouts > 0 {
+		return l.maxLockDuration + l.window
+	}
+	return l.window
+}
+
+func (l *lockout) keys(identifier, ip string) []string {
+	keys := make([]string, 0, 2)
+	if identifier != "" {
+		keys = append(keys, accountKey(identifier))
+	}
+	if ip != "" {
+		keys = append(keys, ipKey(ip))
+	}
+	return keys
+}
+
+func (l *lockout) delayFor(failures int) time.Duration {
+	if l.delay == 0 || failures == 0 {
+		return 0
+	}
+
+	d := l.delay
+	for i := 1; i < failures && d < l.maxDelay; i++ {
+		d *= 2
+	}
+
+	if d > l.maxDelay {
+		return l.maxDelay
+	}
+	return d
+}
+
+func (l *lockout) lockDurationFor(lockouts int) time.Duration {
+	d := l.lockDuration
+	for i := 1; i < lockouts && d < l.maxLockDuration; i++ {
+		d *= 2
+	}
+
+	if d > l.maxLockDuration {
+		return l.maxLockDuration
+	}
+	return d
+}
+
+func accountKey(identifier string) string {
+	return "account:" + identifier
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// clientIP returns the IP of the client. Behind a proxy, set the RemoteAddr of
+// the request to the client address, e.g. with a real IP middleware.
+func clientIP(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+/////////////////////////////////// MEMORY ATTEMPT STORE /////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
+// MemoryAttemptStore is an in-memory AttemptStore, used by default
+type MemoryAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]storedAttempts
+	swept    time.Time
+}
+
+type storedAttempts struct {
+	Attempts
+	expires time.Time
+}
+
+// NewMemoryAttemptStore returns an empty in-memory attempt store
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{
+		attempts: make(map[string]storedAttempts),
+	}
+}
+
+// Attempts returns the attempts of the key
+func (s *MemoryAttemptStore) Attempts(key string) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.attempts[key]
+	if !ok || time.Now().After(a.expires) {
+		delete(s.attempts, key)
+		return Attempts{}, nil
+	}
+	return a.Attempts, nil
+}
+
+// SaveAttempts stores the attempts of the key
+func (s *MemoryAttemptStore) SaveAttempts(key string, a Attempts, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop expired attempts once in a while
+	now := time.Now()
+	if now.Sub(s.swept) > time.Minute {
+		for k, v := range s.attempts {
+			if now.After(v.expires) {
+				delete(s.attempts, k)
+			}
+		}
+		s.swept = now
+	}
+
+	s.attempts[key] = storedAttempts{
+		Attempts: a,
+		expires:  now.Add(ttl),
+	}
+	return nil
+}
+
+// ResetAttempts removes the attempts of the key
+func (s *MemoryAttemptStore) ResetAttempts(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
@@ -0,0 +1,232 @@
+package goauth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type lockoutUser struct {
+	Name     string `json:"name" goauth:"identifier"`
+	Password string `json:"password" goauth:"password"`
+}
+
+// newLockoutAuth returns an authenticator with the user alice, whose password
+// is "secret", and counts the verified attempts in lookups
+func newLockoutAuth(t *testing.T, lookups *int32, options ...LockoutOption) *authenticator {
+	var stored lockoutUser
+
+	options = append([]LockoutOption{LockoutDelay(0, 0)}, options...)
+	auth := New(
+		PasswordHashing(Argon2id(1024, 1, 1), nil),
+		Lookup(func(m map[string]interface{}) (interface{}, error) {
+			atomic.AddInt32(lookups, 1)
+			if m["name"] != "alice" {
+				return nil, nil
+			}
+			return stored, nil
+		}),
+		BruteForceProtection(options...),
+	).(*authenticator)
+
+	hash, err := auth.HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored = lockoutUser{Name: "alice", Password: hash}
+
+	return auth
+}
+
+func login(auth *authenticator, name, password, ip string) error {
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.RemoteAddr = ip + ":1234"
+
+	_, err := auth.checkCredentials(&lockoutUser{Name: name, Password: password}, r)
+	return err
+}
+
+func TestLockoutConcurrentAttempts(t *testing.T) {
+	var lookups int32
+	auth := newLockoutAuth(t, &lookups, LockoutThreshold(5, 100, time.Minute))
+
+	var (
+		wg      sync.WaitGroup
+		invalid int32
+		locked  int32
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := login(auth, "alice", "wrong", "192.0.2.1")
+			switch {
+			case err == ErrorInvalidCredentials:
+				atomic.AddInt32(&invalid, 1)
+			case errors.Is(err, ErrorAccountLocked):
+				atomic.AddInt32(&locked, 1)
+			default:
+				t.Errorf("err = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if lookups != 5 {
+		t.Errorf("verified attempts = %d, want 5", lookups)
+	}
+	if invalid != 5 || locked != 45 {
+		t.Errorf("invalid, locked = %d, %d, want 5, 45", invalid, locked)
+	}
+}
+
+func TestLockoutThreshold(t *testing.T) {
+	tests := []struct {
+		name   string
+		window time.Duration
+		logins []lockoutUser
+		sleep  time.Duration
+		ip     string
+		locked bool
+	}{
+		{
+			name:   "account",
+			window: time.Minute,
+			logins: []lockoutUser{{"alice", "wrong"}, {"alice", "wrong"}, {"alice", "wrong"}},
+			locked: true,
+		},
+		{
+			name:   "below threshold",
+			window: time.Minute,
+			logins: []lockoutUser{{"alice", "wrong"}, {"alice", "wrong"}},
+		},
+		{
+			name:   "success resets account",
+			window: time.Minute,
+			logins: []lockoutUser{{"alice", "wrong"}, {"alice", "wrong"}, {"alice", "secret"}, {"alice", "wrong"}, {"alice", "wrong"}},
+		},
+		{
+			name:   "ip",
+			window: time.Minute,
+			logins: []lockoutUser{{"bob", "wrong"}, {"carol", "wrong"}, {"dave", "wrong"}, {"erin", "wrong"}, {"frank", "wrong"}},
+			ip:     "192.0.2.1",
+			locked: true,
+		},
+		{
+			name:   "other ip",
+			window: time.Minute,
+			logins: []lockoutUser{{"bob", "wrong"}, {"carol", "wrong"}, {"dave", "wrong"}, {"erin", "wrong"}, {"frank", "wrong"}},
+			ip:     "192.0.2.2",
+		},
+		{
+			name:   "window expired",
+			window: 50 * time.Millisecond,
+			logins: []lockoutUser{{"alice", "wrong"}, {"alice", "wrong"}, {"alice", "wrong"}},
+			sleep:  100 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lookups int32
+			auth := newLockoutAuth(t, &lookups, LockoutThreshold(3, 5, tt.window))
+
+			for _, u := range tt.logins {
+				login(auth, u.Name, u.Password, "192.0.2.1")
+			}
+			time.Sleep(tt.sleep)
+
+			name, ip := "alice", tt.ip
+			if ip == "" {
+				ip = "192.0.2.1"
+			} else {
+				name = "mallory"
+			}
+
+			err := login(auth, name, "secret", ip)
+			if got := errors.Is(err, ErrorAccountLocked); got != tt.locked {
+				t.Errorf("locked = %v, want %v (err = %v)", got, tt.locked, err)
+			}
+		})
+	}
+}
+
+func TestLockoutUnlock(t *testing.T) {
+	var lookups int32
+	auth := newLockoutAuth(t, &lookups, LockoutThreshold(1, 0, time.Minute))
+
+	login(auth, "alice", "wrong", "192.0.2.1")
+
+	err := login(auth, "alice", "secret", "192.0.2.1")
+	var locked *AccountLockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("err = %v, want %v", err, ErrorAccountLocked)
+	}
+	if d := time.Until(locked.Until); d <= 0 || d > time.Minute {
+		t.Errorf("locked for %v, want (0, 1m]", d)
+	}
+
+	err = auth.Unlock("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = login(auth, "alice", "secret", "192.0.2.1")
+	if err != nil {
+		t.Errorf("err = %v, want <nil>", err)
+	}
+}
+
+func TestLockoutDelay(t *testing.T) {
+	l := &lockout{delay: 250 * time.Millisecond, maxDelay: 4 * time.Second}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, 250 * time.Millisecond},
+		{2, 500 * time.Millisecond},
+		{3, time.Second},
+		{5, 4 * time.Second},
+		{6, 4 * time.Second},
+		{100, 4 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := l.delayFor(tt.failures); got != tt.want {
+			t.Errorf("delayFor(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+
+	l.delay = 0
+	if got := l.delayFor(3); got != 0 {
+		t.Errorf("disabled delayFor(3) = %v, want 0", got)
+	}
+}
+
+func TestLockoutDuration(t *testing.T) {
+	l := &lockout{lockDuration: time.Minute, maxLockDuration: time.Hour}
+
+	tests := []struct {
+		lockouts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{6, 32 * time.Minute},
+		{7, time.Hour},
+		{100, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := l.lockDurationFor(tt.lockouts); got != tt.want {
+			t.Errorf("lockDurationFor(%d) = %v, want %v", tt.lockouts, got, tt.want)
+		}
+	}
+}
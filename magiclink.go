@@ -0,0 +1,289 @@
+package goauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MagicLinkCookie is the name of the cookie binding magic links to the browser
+// which requested them
+const MagicLinkCookie = "goauth_magic"
+
+type magicLinks struct {
+	key    []byte
+	sender Sender
+	url    *url.URL
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	links map[string]magicLink
+}
+
+type magicLink struct {
+	lookup  map[string]interface{}
+	binding []byte
+	expires time.Time
+}
+
+// MagicLinks enables passwordless logins via links delivered by the sender to
+// the field tagged with goauth:"email", or the identifier if there is none. The
+// token is appended to link as "token" query parameter. Tokens are signed with
+// key, valid for ttl (15 minutes by default) and can be used once.
+func MagicLinks(key []byte, sender Sender, link string, ttl time.Duration) AuthenticatorOption {
+	return func(auth *authenticator) {
+		if len(key) < 32 {
+			panic("The magic link key must be at least 32 bytes long")
+		}
+
+		if sender == nil {
+			panic("Sender cannot be nil")
+		}
+
+		u, err := url.Parse(link)
+		if err != nil || !u.IsAbs() {
+			panic("The magic link URL must be absolute")
+		}
+
+		if ttl <= 0 {
+			ttl = 15 * time.Minute
+		}
+
+		auth.magicLinks = &magicLinks{
+			key:    key,
+			sender: sender,
+			url:    u,
+			ttl:    ttl,
+			links:  make(map[string]magicLink),
+		}
+	}
+}
+
+// SendMagicLink looks up the user and sends a magic link. The returned cookie
+// binds the link to the browser of the request and has to be set in the
+// response. Unknown users get a cookie too, but no link, so the response
+// doesn't reveal which users exist.
+func (auth *authenticator) SendMagicLink(user interface{}, r *http.Request) (*http.Cookie, error) {
+	ml := auth.magicLinks
+	if ml == nil {
+		return nil, ErrorMagicLinksDisabled
+	}
+
+	nonce, err := ml.browserNonce(r)
+	if err != nil {
+		return nil, err
+	}
+	cookie := ml.cookie(nonce)
+
+	m, err := userMap(user)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := auth.lookupMethod.Do(m)
+	if err != nil {
+		return nil, err
+	}
+
+	if isNil(stored) {
+		return cookie, nil
+	}
+
+	to := emailAddress(stored)
+	if to == "" {
+		return nil, ErrorEmailMissing
+	}
+
+	id, err := randomCryptoString(20)
+	if err != nil {
+		return nil, err
+	}
+
+	binding := sha256.Sum256([]byte(nonce))
+	expires := time.Now().Add(ml.ttl)
+	ml.add(id, magicLink{
+		lookup:  m,
+		binding: binding[:],
+		expires: expires,
+	})
+
+	err = ml.sender.Send(Message{
+		To:      to,
+		Subject: "Your login link",
+		Body: fmt.Sprintf("Open this link to log in: %s\n\nIt is valid for %s and can only be used once. "+
+			"If you didn't request it, you can ignore this email.", ml.link(id, expires), ml.ttl),
+	})
+	if err != nil {
+		ml.remove(id)
+		return nil, err
+	}
+
+	return cookie, nil
+}
+
+// IdentifyMagicLink validates and consumes the magic link token of the request
+// and returns a context for the user, ready for Authenticate unless the user
+// uses 2FA. The link has to be opened in the browser it was requested in.
+func (auth *authenticator) IdentifyMagicLink(r *http.Request) (Context, error) {
+	ml := auth.magicLinks
+	if ml == nil {
+		return nil, ErrorMagicLinksDisabled
+	}
+
+	id, ok := ml.verify(r.URL.Query().Get("token"))
+	if !ok {
+		return nil, ErrorMagicLinkInvalid
+	}
+
+	// The token isn't consumed on a binding mismatch, so forwarded links can't
+	// be burned by others
+	cookie, err := r.Cookie(MagicLinkCookie)
+	if err != nil {
+		return nil, ErrorMagicLinkBrowser
+	}
+
+	link, err := ml.consume(id, cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	// The user is looked up again, so users removed in the meantime are rejected
+	stored, err := auth.lookupMethod.Do(link.lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	if isNil(stored) {
+		return nil, ErrorMagicLinkInvalid
+	}
+
+	// A magic link proves access to the mailbox, not knowledge of a code, so it
+	// isn't recorded as "otp"
+	return auth.userContext(stored, r, "mail")
+}
+
+// browserNonce returns the nonce of the binding cookie or a new one
+func (ml *magicLinks) browserNonce(r *http.Request) (string, error) {
+	if r != nil {
+		if c, err := r.Cookie(MagicLinkCookie); err == nil && len(c.Value) >= 32 {
+			return c.Value, nil
+		}
+	}
+
+	return randomCryptoString(32)
+}
+
+func (ml *magicLinks) cookie(nonce string) *http.Cookie {
+	return &http.Cookie{
+		Name:     MagicLinkCookie,
+		Value:    nonce,
+		Path:     "/",
+		Expires:  time.Now().Add(ml.ttl),
+		MaxAge:   int(ml.ttl.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		// Lax, as the link is opened from an email client
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// link creates the URL with the token in the form <id>.<expires>.<signature>
+func (ml *magicLinks) link(id string, expires time.Time) string {
+	payload := id + "." + strconv.FormatInt(expires.Unix(), 10)
+
+	u := *ml.url
+	q := u.Query()
+	q.Set("token", payload+"."+ml.sign(payload))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func (ml *magicLinks) sign(payload string) string {
+	mac := hmac.New(sha256.New, ml.key)
+	mac.Write([]byte("magic-link"))
+	mac.Write([]byte{0})
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the signature and expiry of the token and returns its ID
+func (ml *magicLinks) verify(token string) (string, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(ml.sign(payload))) {
+		return "", false
+	}
+
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", false
+	}
+
+	return parts[0], true
+}
+
+func (ml *magicLinks) add(id string, link magicLink) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	now := time.Now()
+	for k, l := range ml.links {
+		if now.After(l.expires) {
+			delete(ml.links, k)
+		}
+	}
+
+	ml.links[id] = link
+}
+
+// consume removes the link if the nonce of the browser matches its binding
+func (ml *magicLinks) consume(id, nonce string) (magicLink, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	l, ok := ml.links[id]
+	if !ok || time.Now().After(l.expires) {
+		delete(ml.links, id)
+		return magicLink{}, ErrorMagicLinkInvalid
+	}
+
+	binding := sha256.Sum256([]byte(nonce))
+	if !hmac.Equal(binding[:], l.binding) {
+		return magicLink{}, ErrorMagicLinkBrowser
+	}
+
+	delete(ml.links, id)
+	return l, nil
+}
+
+func (ml *magicLinks) remove(id string) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	delete(ml.links, id)
+}
+
+// emailAddress returns the field of the user tagged with goauth:"email" or the
+// identifier
+func emailAddress(user interface{}) string {
+	tags := getTags(user)
+	for _, name := range []string{"email", "identifier"} {
+		if v, ok := tags[name]; ok && v != nil {
+			if s := fmt.Sprint(v); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
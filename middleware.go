@@ -1,12 +1,28 @@
 package goauth
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/labstack/echo"
 )
 
+// MiddlewareOption represents a requirement of a protected route
+type MiddlewareOption func(m *middleware)
+
+type middleware struct {
+	auth          *authenticator
+	maxAuthAge    time.Duration
+	factors       []string
+	verifiedEmail bool
+	roles         []string
+	scopes        []string
+	anyOf         [][]*middleware
+}
+
 // Redirect sets the path to redirect to if the user is unauthorized
 func Redirect(target string) AuthenticatorOption {
 	return func(auth *authenticator) {
@@ -15,64 +31,236 @@ func Redirect(target string) AuthenticatorOption {
 	}
 }
 
+// MaxAuthAge requires the user to have authenticated within the max age, e.g.
+// for changing the password
+func MaxAuthAge(age time.Duration) MiddlewareOption {
+	return func(m *middleware) {
+		if age <= 0 {
+			panic("The max auth age must be > 0")
+		}
+		m.maxAuthAge = age
+	}
+}
+
+// RequireFactors requires the user to have authenticated with all factors, e.g.
+// "otp" or "hwk" as recorded in the "amr" claim
+func RequireFactors(factors ...string) MiddlewareOption {
+	return func(m *middleware) {
+		m.factors = append(m.factors, factors...)
+	}
+}
+
+// RequireVerifiedEmail requires the "email_verified" claim, which is set from the
+// field tagged with goauth:"email_verified", e.g. Fields.EmailVerified
+func RequireVerifiedEmail() MiddlewareOption {
+	return func(m *middleware) {
+		m.verifiedEmail = true
+	}
+}
+
+func (auth *authenticator) newMiddleware(options []MiddlewareOption) *middleware {
+	m := &middleware{auth: auth}
+	for _, f := range options {
+		f(m)
+	}
+	return m
+}
+
 // Middleware provides a middleware func for the net/http to protect routes
 func (auth *authenticator) Middleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		t, err := auth.authMethod.Lookup(r)
-		if err != nil {
-			auth.json(w, http.StatusInternalServerError, ErrorKeyLookup(err))
-		}
+	return auth.MiddlewareWith()(next)
+}
 
-		token, err := auth.authMethod.Validate(t)
-		if !token.Valid || err != nil {
-			if auth.redirect {
-				auth.redirectTo(w, r, auth.redirectTarget)
+// MiddlewareWith provides a middleware func for the net/http to protect routes
+// with additional requirements
+func (auth *authenticator) MiddlewareWith(options ...MiddlewareOption) func(http.Handler) http.Handler {
+	m := auth.newMiddleware(options)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t, err := auth.lookupToken(r)
+			if err != nil {
+				auth.json(w, http.StatusInternalServerError, ErrorKeyLookup(err))
+				return
 			}
-			auth.json(w, http.StatusUnauthorized, StatusUnauthorized(err))
-		}
 
-		next.ServeHTTP(w, r)
-	})
+			claims, err := auth.validClaims(t)
+			if err != nil {
+				if auth.redirect {
+					auth.redirectTo(w, r, auth.redirectTarget)
+					return
+				}
+				auth.json(w, http.StatusUnauthorized, StatusUnauthorized(err))
+				return
+			}
+
+			failed, err := m.verify(claims)
+			if err != nil {
+				code, challenge, body := failed.reject(err)
+				if challenge != "" {
+					w.Header().Set("WWW-Authenticate", challenge)
+				}
+				auth.json(w, code, body)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 // EchoMiddleware provides a middleware func for the echo framework to protect routes
-func (auth *authenticator) EchoMiddleware() echo.MiddlewareFunc {
+func (auth *authenticator) EchoMiddleware(options ...MiddlewareOption) echo.MiddlewareFunc {
+	m := auth.newMiddleware(options)
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			t, err := auth.authMethod.Lookup(c.Request())
-			// if err != nil {
-			// 	return c.JSON(http.StatusInternalServerError, ErrorKeyLookup(err))
-			// }
+			t, err := auth.lookupToken(c.Request())
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, ErrorKeyLookup(err))
+			}
 
-			token, err := auth.authMethod.Validate(t)
-			if !token.Valid || err != nil {
+			claims, err := auth.validClaims(t)
+			if err != nil {
 				if auth.redirect {
 					return c.Redirect(http.StatusMovedPermanently, auth.redirectTarget)
 				}
 				return c.JSON(http.StatusUnauthorized, StatusUnauthorized(err))
 			}
 
+			failed, err := m.verify(claims)
+			if err != nil {
+				code, challenge, body := failed.reject(err)
+				if challenge != "" {
+					c.Response().Header().Set("WWW-Authenticate", challenge)
+				}
+				return c.JSON(code, body)
+			}
+
 			return next(c)
 		}
 	}
 }
 
 // GinMiddleware provides a middleware func for the gin framework to protect routes
-func (auth *authenticator) GinMiddleware() gin.HandlerFunc {
+func (auth *authenticator) GinMiddleware(options ...MiddlewareOption) gin.HandlerFunc {
+	m := auth.newMiddleware(options)
 	return func(c *gin.Context) {
-		t, err := auth.authMethod.Lookup(c.Request)
+		t, err := auth.lookupToken(c.Request)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorKeyLookup(err))
+			c.AbortWithStatusJSON(http.StatusInternalServerError, ErrorKeyLookup(err))
+			return
 		}
 
-		token, err := auth.authMethod.Validate(t)
-		if !token.Valid || err != nil {
+		claims, err := auth.validClaims(t)
+		if err != nil {
 			if auth.redirect {
 				c.Redirect(http.StatusMovedPermanently, auth.redirectTarget)
+				c.Abort()
+				return
 			}
-			c.JSON(http.StatusUnauthorized, StatusUnauthorized(err))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, StatusUnauthorized(err))
+			return
+		}
+
+		failed, err := m.verify(claims)
+		if err != nil {
+			code, challenge, body := failed.reject(err)
+			if challenge != "" {
+				c.Header("WWW-Authenticate", challenge)
+			}
+			c.AbortWithStatusJSON(code, body)
+			return
 		}
 
 		c.Next()
 	}
 }
+
+// verify checks the claims against the requirements. It returns the error of
+// the unmet requirement and the middleware it belongs to, i.e. m or one of its
+// alternatives.
+func (m *middleware) verify(claims map[string]interface{}) (*middleware, error) {
+	if m.maxAuthAge > 0 {
+		authTime := claimsAuthTime(claims)
+		if authTime.IsZero() || time.Since(authTime) > m.maxAuthAge {
+			return m, ErrorAuthTooOld
+		}
+	}
+
+	if !containsAll(claimsAMR(claims), m.factors) {
+		return m, ErrorMissingFactors
+	}
+
+	if m.verifiedEmail {
+		if verified, _ := claims["email_verified"].(bool); !verified {
+			return m, ErrorEmailNotVerified
+		}
+	}
+
+	if !containsAll(claimValues(claims, m.auth.rolesClaim), m.roles) {
+		return m, ErrorMissingRoles
+	}
+
+	if !containsAll(claimValues(claims, m.auth.scopesClaim), m.scopes) {
+		return m, ErrorMissingScopes
+	}
+
+	// The first alternative is returned along with its error if none is met
+	for _, alternatives := range m.anyOf {
+		var (
+			failed *middleware
+			first  error
+		)
+		for _, a := range alternatives {
+			f, err := a.verify(claims)
+			if err == nil {
+				first = nil
+				break
+			}
+			if first == nil {
+				failed, first = f, err
+			}
+		}
+
+		if first != nil {
+			return failed, first
+		}
+	}
+
+	return m, nil
+}
+
+// reject returns the status code, WWW-Authenticate challenge and body for the
+// unmet requirement of the middleware returned by verify. Users have to
+// authenticate again for step-up requirements, other requirements are forbidden.
+func (m *middleware) reject(err error) (int, string, map[string]interface{}) {
+	switch err {
+	case ErrorAuthTooOld, ErrorMissingFactors:
+		return http.StatusUnauthorized, m.challenge(err), StatusStepUp(err, m.maxAuthAge, m.factors)
+	case ErrorMissingRoles, ErrorMissingScopes:
+		return http.StatusForbidden, m.scopeChallenge(err), StatusInsufficientScope(err, m.roles, m.scopes)
+	default:
+		return http.StatusForbidden, "", StatusForbidden(err)
+	}
+}
+
+// challenge returns the step-up challenge for the WWW-Authenticate header
+// (RFC 9470)
+func (m *middleware) challenge(err error) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `Bearer error="insufficient_user_authentication", error_description=%q`, err.Error())
+	if m.maxAuthAge > 0 {
+		fmt.Fprintf(&b, `, max_age="%d"`, int64(m.maxAuthAge.Seconds()))
+	}
+	return b.String()
+}
+
+// scopeChallenge returns the insufficient scope challenge for the
+// WWW-Authenticate header (RFC 6750)
+func (m *middleware) scopeChallenge(err error) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `Bearer error="insufficient_scope", error_description=%q`, err.Error())
+	if len(m.scopes) > 0 {
+		fmt.Fprintf(&b, `, scope="%s"`, strings.Join(m.scopes, " "))
+	}
+	return b.String()
+}
@@ -0,0 +1,190 @@
+package goauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OneTimeCode is a 2FA method which delivers short-lived numeric codes to the
+// user, e.g. via email or SMS
+type OneTimeCode struct {
+	name        string
+	digits      int
+	ttl         time.Duration
+	maxAttempts int
+	sender      Sender
+	key         []byte
+
+	mu    sync.Mutex
+	codes map[string]pendingCode
+}
+
+type pendingCode struct {
+	hash     []byte
+	expires  time.Time
+	attempts int
+}
+
+// EmailCode registers one-time codes delivered via email as a 2FA method. The
+// 2FA user of your users has to be their email address.
+func EmailCode(sender Sender, digits int, ttl time.Duration) AuthenticatorOption {
+	return func(auth *authenticator) {
+		auth.twoFaMethods["email"] = newOneTimeCode("email", sender, digits, ttl)
+	}
+}
+
+// SMSCode registers one-time codes delivered via SMS as a 2FA method. The 2FA
+// user of your users has to be their phone number.
+func SMSCode(sender Sender, digits int, ttl time.Duration) AuthenticatorOption {
+	return func(auth *authenticator) {
+		auth.twoFaMethods["sms"] = newOneTimeCode("sms", sender, digits, ttl)
+	}
+}
+
+func newOneTimeCode(name string, sender Sender, digits int, ttl time.Duration) TwoFAMethod {
+	if sender == nil {
+		panic("Sender cannot be nil")
+	}
+
+	if digits < 6 || digits > 10 {
+		panic("One-time codes must have between 6 and 10 digits")
+	}
+
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+
+	key, err := randomCryptoBytes(32)
+	if err != nil {
+		panic(err)
+	}
+
+	return &OneTimeCode{
+		name:        name,
+		digits:      digits,
+		ttl:         ttl,
+		maxAttempts: 5,
+		sender:      sender,
+		key:         key,
+		codes:       make(map[string]pendingCode),
+	}
+}
+
+// Generate generates a new code for the account and delivers it. It returns the
+// masked destination, which can be shown to the user. A previously generated
+// code of the account becomes invalid.
+func (o *OneTimeCode) Generate(account string) (string, error) {
+	code, err := randomDigits(o.digits)
+	if err != nil {
+		return "", err
+	}
+
+	o.mu.Lock()
+	now := time.Now()
+	for k, c := range o.codes {
+		if now.After(c.expires) {
+			delete(o.codes, k)
+		}
+	}
+
+	o.codes[account] = pendingCode{
+		hash:    o.hash(account, code),
+		expires: now.Add(o.ttl),
+	}
+	o.mu.Unlock()
+
+	err = o.sender.Send(Message{
+		To:      account,
+		Subject: "Your verification code",
+		Body:    fmt.Sprintf("Your verification code is %s. It is valid for %s.", code, o.ttl),
+	})
+	if err != nil {
+		o.mu.Lock()
+		delete(o.codes, account)
+		o.mu.Unlock()
+		return "", err
+	}
+
+	return maskDestination(account), nil
+}
+
+// Register delivers a code to the account to verify it is reachable. There is no
+// secret, so the first return value is empty.
+func (o *OneTimeCode) Register(account string) (string, string, error) {
+	dest, err := o.Generate(account)
+	return "", dest, err
+}
+
+// Secret is not used by one-time codes, it returns random bytes to satisfy the
+// TwoFAMethod interface
+func (o *OneTimeCode) Secret() ([]byte, error) {
+	return randomCryptoBytes(16)
+}
+
+// Secretless reports that one-time codes don't use a 2FA secret
+func (o *OneTimeCode) Secretless() bool {
+	return true
+}
+
+// Validate always fails, as the code is stored per user. Use ValidateUser
+// instead.
+func (o *OneTimeCode) Validate(code, secret string) bool {
+	return false
+}
+
+// ValidateUser validates the code generated for the user. Each code can only be
+// used once and is discarded after too many failed attempts.
+func (o *OneTimeCode) ValidateUser(user, code, secret string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	c, ok := o.codes[user]
+	if !ok {
+		return false
+	}
+
+	if time.Now().After(c.expires) {
+		delete(o.codes, user)
+		return false
+	}
+
+	if hmac.Equal(c.hash, o.hash(user, strings.TrimSpace(code))) {
+		delete(o.codes, user)
+		return true
+	}
+
+	c.attempts++
+	if c.attempts >= o.maxAttempts {
+		delete(o.codes, user)
+		return false
+	}
+
+	o.codes[user] = c
+	return false
+}
+
+func (o *OneTimeCode) hash(user, code string) []byte {
+	m := hmac.New(sha256.New, o.key)
+	m.Write([]byte(user))
+	m.Write([]byte{0})
+	m.Write([]byte(code))
+	return m.Sum(nil)
+}
+
+// maskDestination masks an email address or phone number, e.g. j***@example.com
+// or ******7890
+func maskDestination(d string) string {
+	if i := strings.LastIndex(d, "@"); i > 0 {
+		return d[:1] + "***" + d[i:]
+	}
+
+	if len(d) <= 4 {
+		return d
+	}
+
+	return strings.Repeat("*", len(d)-4) + d[len(d)-4:]
+}
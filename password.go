@@ -0,0 +1,382 @@
+package goauth
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PasswordHasher hashes passwords into PHC formatted strings, e.g.
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+type PasswordHasher interface {
+	// Hash hashes the password with a random salt
+	Hash(password string) (string, error)
+
+	// Verify verifies the password against the hash in constant time. It
+	// returns ErrorPasswordHashFormat if the hash isn't of its format.
+	Verify(password, hash string) (bool, error)
+
+	// NeedsRehash reports whether the hash was created with another algorithm
+	// or other parameters
+	NeedsRehash(hash string) bool
+}
+
+// PasswordHashing sets the hasher for new password hashes, by default argon2id
+// with DefaultArgon2id parameters. Hashes of all built-in algorithms can be
+// verified. If a verified hash needs a rehash, the new hash is passed to the
+// rehash function along with the user to be persisted.
+func PasswordHashing(hasher PasswordHasher, rehash func(user interface{}, hash string) error) AuthenticatorOption {
+	return func(auth *authenticator) {
+		if hasher == nil {
+			panic("Password hasher cannot be nil")
+		}
+
+		auth.hasher = hasher
+		auth.rehash = rehash
+	}
+}
+
+// RehashErrors sets the function called when the rehash function of
+// PasswordHashing fails. The password was verified nonetheless, so the login
+// succeeds. By default the error is logged.
+func RehashErrors(f func(user interface{}, err error)) AuthenticatorOption {
+	return func(auth *authenticator) {
+		if f == nil {
+			panic("Rehash error function cannot be nil")
+		}
+		auth.rehashError = f
+	}
+}
+
+// HashPassword hashes the password with the configured hasher, e.g. on sign up
+func (auth *authenticator) HashPassword(password string) (string, error) {
+	return auth.hasher.Hash(password)
+}
+
+// VerifyPassword verifies the password of the user against the hash. On success
+// outdated hashes are upgraded via the rehash function of PasswordHashing. A
+// failed upgrade doesn't fail the verification, it is reported via RehashErrors.
+func (auth *authenticator) VerifyPassword(user interface{}, password, hash string) (bool, error) {
+	ok, err := auth.hasher.Verify(password, hash)
+	if err == ErrorPasswordHashFormat {
+		ok, err = verifyPassword(password, hash)
+	}
+
+	if err != nil || !ok {
+		return false, err
+	}
+
+	if auth.rehash != nil && auth.hasher.NeedsRehash(hash) {
+		newHash, err := auth.hasher.Hash(password)
+		if err == nil {
+			err = auth.rehash(user, newHash)
+		}
+
+		if err != nil {
+			auth.rehashError(user, err)
+		}
+	}
+
+	return true, nil
+}
+
+// logRehashError is the default function of RehashErrors
+func logRehashError(user interface{}, err error) {
+	log.Printf("goauth: rehashing the password failed: %v", err)
+}
+
+// verifyPassword verifies the password against a hash of any built-in algorithm
+func verifyPassword(password, hash string) (bool, error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return (&Argon2idHasher{}).Verify(password, hash)
+	case strings.HasPrefix(hash, "$scrypt$"):
+		return (&ScryptHasher{}).Verify(password, hash)
+	case isBcryptHash(hash):
+		return (&BcryptHasher{}).Verify(password, hash)
+	default:
+		return false, ErrorPasswordHashFormat
+	}
+}
+
+// splitPHC splits a PHC string of the algorithm id into its n fields, e.g. the
+// parameters, salt and hash
+func splitPHC(hash, id string, n int) ([]string, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != n+2 || parts[0] != "" || parts[1] != id {
+		return nil, ErrorPasswordHashFormat
+	}
+
+	return parts[2:], nil
+}
+
+func decodePHC(salt, hash string) ([]byte, []byte, error) {
+	s, err := base64.RawStdEncoding.DecodeString(salt)
+	if err != nil {
+		return nil, nil, ErrorPasswordHashFormat
+	}
+
+	h, err := base64.RawStdEncoding.DecodeString(hash)
+	if err != nil || len(h) == 0 {
+		return nil, nil, ErrorPasswordHashFormat
+	}
+
+	return s, h, nil
+}
+
+func encodePHC(s []byte) string {
+	return base64.RawStdEncoding.EncodeToString(s)
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+////////////////////////////////////////// ARGON2ID //////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
+// Argon2idHasher hashes passwords with argon2id (RFC 9106)
+type Argon2idHasher struct {
+	// Memory in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2id returns an argon2id hasher with the parameters recommended by
+// RFC 9106 for memory constrained environments: 64 MiB, 3 iterations, 4 lanes
+func DefaultArgon2id() *Argon2idHasher {
+	return Argon2id(64*1024, 3, 4)
+}
+
+// Argon2id returns an argon2id hasher. Memory is in KiB.
+func Argon2id(memory, iterations uint32, parallelism uint8) *Argon2idHasher {
+	if memory < 8*uint32(parallelism) || iterations == 0 || parallelism == 0 {
+		panic("Invalid argon2id parameters")
+	}
+
+	return &Argon2idHasher{
+		Memory:      memory,
+		Iterations:  iterations,
+		Parallelism: parallelism,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+// Hash hashes the password
+func (a *Argon2idHasher) Hash(password string) (string, error) {
+	salt, err := randomCryptoBytes(int(a.SaltLength))
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.Memory, a.Iterations, a.Parallelism, encodePHC(salt), encodePHC(key)), nil
+}
+
+// Verify verifies the password with the parameters of the hash
+func (a *Argon2idHasher) Verify(password, hash string) (bool, error) {
+	p, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+
+	k := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(k, key) == 1, nil
+}
+
+// NeedsRehash reports whether the hash isn't an argon2id hash with the
+// parameters of the hasher
+func (a *Argon2idHasher) NeedsRehash(hash string) bool {
+	p, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return true
+	}
+
+	return p.Memory != a.Memory || p.Iterations != a.Iterations || p.Parallelism != a.Parallelism ||
+		uint32(len(salt)) != a.SaltLength || uint32(len(key)) != a.KeyLength
+}
+
+func parseArgon2id(hash string) (*Argon2idHasher, []byte, []byte, error) {
+	parts, err := splitPHC(hash, "argon2id", 4)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var version int
+	_, err = fmt.Sscanf(parts[0], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, nil, nil, ErrorPasswordHashFormat
+	}
+
+	p := &Argon2idHasher{}
+	_, err = fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism)
+	if err != nil || p.Iterations == 0 || p.Parallelism == 0 || p.Memory < 8*uint32(p.Parallelism) {
+		return nil, nil, nil, ErrorPasswordHashFormat
+	}
+
+	salt, key, err := decodePHC(parts[2], parts[3])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return p, salt, key, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+/////////////////////////////////////////// BCRYPT ///////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
+// BcryptHasher hashes passwords with bcrypt. Only the first 72 bytes of a
+// password are used by bcrypt, longer passwords are rejected.
+type BcryptHasher struct {
+	Cost int
+}
+
+// Bcrypt returns a bcrypt hasher with the cost
+func Bcrypt(cost int) *BcryptHasher {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		panic("Invalid bcrypt cost")
+	}
+
+	return &BcryptHasher{Cost: cost}
+}
+
+// Hash hashes the password
+func (b *BcryptHasher) Hash(password string) (string, error) {
+	if len(password) > 72 {
+		return "", ErrorPasswordTooLong
+	}
+
+	h, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	return string(h), err
+}
+
+// Verify verifies the password
+func (b *BcryptHasher) Verify(password, hash string) (bool, error) {
+	if !isBcryptHash(hash) {
+		return false, ErrorPasswordHashFormat
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	switch err {
+	case nil:
+		return true, nil
+	case bcrypt.ErrMismatchedHashAndPassword:
+		return false, nil
+	default:
+		return false, ErrorPasswordHashFormat
+	}
+}
+
+// NeedsRehash reports whether the hash isn't a bcrypt hash with the cost of the
+// hasher
+func (b *BcryptHasher) NeedsRehash(hash string) bool {
+	if !isBcryptHash(hash) {
+		return true
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != b.Cost
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+/////////////////////////////////////////// SCRYPT ///////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
+// ScryptHasher hashes passwords with scrypt (RFC 7914)
+type ScryptHasher struct {
+	// LogN is the binary logarithm of the CPU/memory cost N
+	LogN       uint8
+	R          int
+	P          int
+	SaltLength int
+	KeyLength  int
+}
+
+// Scrypt returns a scrypt hasher with the cost N = 2^logN
+func Scrypt(logN uint8, r, p int) *ScryptHasher {
+	if logN < 1 || logN > 30 || r <= 0 || p <= 0 {
+		panic("Invalid scrypt parameters")
+	}
+
+	return &ScryptHasher{
+		LogN:       logN,
+		R:          r,
+		P:          p,
+		SaltLength: 16,
+		KeyLength:  32,
+	}
+}
+
+// Hash hashes the password
+func (s *ScryptHasher) Hash(password string) (string, error) {
+	salt, err := randomCryptoBytes(s.SaltLength)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := scrypt.Key([]byte(password), salt, 1<<s.LogN, s.R, s.P, s.KeyLength)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", s.LogN, s.R, s.P, encodePHC(salt), encodePHC(key)), nil
+}
+
+// Verify verifies the password with the parameters of the hash
+func (s *ScryptHasher) Verify(password, hash string) (bool, error) {
+	p, salt, key, err := parseScrypt(hash)
+	if err != nil {
+		return false, err
+	}
+
+	k, err := scrypt.Key([]byte(password), salt, 1<<p.LogN, p.R, p.P, len(key))
+	if err != nil {
+		return false, ErrorPasswordHashFormat
+	}
+
+	return subtle.ConstantTimeCompare(k, key) == 1, nil
+}
+
+// NeedsRehash reports whether the hash isn't a scrypt hash with the parameters
+// of the hasher
+func (s *ScryptHasher) NeedsRehash(hash string) bool {
+	p, salt, key, err := parseScrypt(hash)
+	if err != nil {
+		return true
+	}
+
+	return p.LogN != s.LogN || p.R != s.R || p.P != s.P || len(salt) != s.SaltLength || len(key) != s.KeyLength
+}
+
+func parseScrypt(hash string) (*ScryptHasher, []byte, []byte, error) {
+	parts, err := splitPHC(hash, "scrypt", 3)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	p := &ScryptHasher{}
+	_, err = fmt.Sscanf(parts[0], "ln=%d,r=%d,p=%d", &p.LogN, &p.R, &p.P)
+	if err != nil || p.LogN < 1 || p.LogN > 30 || p.R <= 0 || p.P <= 0 {
+		return nil, nil, nil, ErrorPasswordHashFormat
+	}
+
+	salt, key, err := decodePHC(parts[1], parts[2])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return p, salt, key, nil
+}
@@ -0,0 +1,556 @@
+package goauth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Password policy violation codes
+const (
+	PasswordTooShort    = "too_short"
+	PasswordTooLong     = "too_long"
+	PasswordTooWeak     = "too_weak"
+	PasswordContextWord = "context_word"
+	PasswordBreached    = "breached"
+)
+
+// PasswordViolation is a violated rule of the password policy
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyOption represents a password policy option
+type PasswordPolicyOption func(p *passwordPolicy)
+
+type passwordPolicy struct {
+	minLength    int
+	maxLength    int
+	minEntropy   float64
+	contextWords []string
+	breachFile   string
+	breachDir    string
+}
+
+// PasswordPolicy enforces the policy in CheckPassword. By default passwords
+// need at least 8 characters and an estimated entropy of 30 bits.
+func PasswordPolicy(options ...PasswordPolicyOption) AuthenticatorOption {
+	return func(auth *authenticator) {
+		p := &passwordPolicy{
+			minLength:  8,
+			maxLength:  128,
+			minEntropy: 30,
+		}
+
+		for _, f := range options {
+			f(p)
+		}
+
+		auth.policy = p
+	}
+}
+
+// PasswordLength sets the minimum and maximum number of characters
+func PasswordLength(min, max int) PasswordPolicyOption {
+	return func(p *passwordPolicy) {
+		if min < 1 || max < min {
+			panic("Invalid password length limits")
+		}
+		p.minLength = min
+		p.maxLength = max
+	}
+}
+
+// PasswordMinEntropy sets the minimum estimated entropy in bits
+func PasswordMinEntropy(bits float64) PasswordPolicyOption {
+	return func(p *passwordPolicy) {
+		p.minEntropy = bits
+	}
+}
+
+// PasswordContextWords disallows words in all passwords, e.g. the name of your
+// site. Words of the user are passed to CheckPassword.
+func PasswordContextWords(words ...string) PasswordPolicyOption {
+	return func(p *passwordPolicy) {
+		p.contextWords = append(p.contextWords, words...)
+	}
+}
+
+// PasswordBreachFile checks passwords against a local file of breached
+// passwords, with one uppercase SHA-1 hash per line, optionally followed by
+// ":<count>", sorted by hash. This is the format of the HIBP downloads.
+func PasswordBreachFile(path string) PasswordPolicyOption {
+	return func(p *passwordPolicy) {
+		p.breachFile = path
+	}
+}
+
+// PasswordBreachRangeDir checks passwords against a directory of HIBP range
+// files. Each file is named by the first 5 hex characters of the hashes, with
+// or without .txt extension, and lists the remaining 35 characters as
+// "<suffix>:<count>" lines.
+func PasswordBreachRangeDir(dir string) PasswordPolicyOption {
+	return func(p *passwordPolicy) {
+		p.breachDir = dir
+	}
+}
+
+// CheckPassword checks the password against the password policy, e.g. on sign
+// up or password change. Pass words related to the user, like their username and
+// email, as context. An empty result means the password is accepted.
+func (auth *authenticator) CheckPassword(password string, context ...string) ([]PasswordViolation, error) {
+	p := auth.policy
+	if p == nil {
+		return nil, ErrorPasswordPolicyDisabled
+	}
+
+	violations := make([]PasswordViolation, 0)
+	n := utf8.RuneCountInString(password)
+	if n < p.minLength {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordTooShort,
+			Message: fmt.Sprintf("The password must have at least %d characters", p.minLength),
+		})
+	}
+
+	// Don't spend time on estimating or hashing overlong passwords
+	if n > p.maxLength {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordTooLong,
+			Message: fmt.Sprintf("The password must have at most %d characters", p.maxLength),
+		})
+		return violations, nil
+	}
+
+	words := contextWords(append(append([]string(nil), p.contextWords...), context...))
+	lower := strings.ToLower(password)
+	for _, w := range words {
+		if strings.Contains(lower, w) {
+			violations = append(violations, PasswordViolation{
+				Code:    PasswordContextWord,
+				Message: fmt.Sprintf("The password must not contain %q", w),
+			})
+			break
+		}
+	}
+
+	if PasswordEntropy(password, words...) < p.minEntropy {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordTooWeak,
+			Message: "The password is too easy to guess, use a longer password or more unrelated words",
+		})
+	}
+
+	breached, err := p.breached(password)
+	if err != nil {
+		return nil, err
+	}
+
+	if breached {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordBreached,
+			Message: "The password appeared in a data breach, choose another password",
+		})
+	}
+
+	return violations, nil
+}
+
+// contextWords splits the context into lowercase words, e.g. emails into the
+// local part and domain labels. Words shorter than 3 characters are ignored.
+func contextWords(context []string) []string {
+	words := make([]string, 0, len(context))
+	for _, c := range context {
+		fields := strings.FieldsFunc(strings.ToLower(c), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+
+		for _, f := range fields {
+			if utf8.RuneCountInString(f) >= 3 && !containsString(words, f) {
+				words = append(words, f)
+			}
+		}
+	}
+	return words
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+/////////////////////////////////////// ENTROPY //////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
+// commonPasswords are frequent passwords and password fragments, ordered by rank
+var commonPasswords = []string{
+	"password", "123456", "qwerty", "letmein", "welcome", "admin", "login", "master",
+	"dragon", "monkey", "football", "baseball", "iloveyou", "sunshine", "princess",
+	"shadow", "superman", "batman", "trustno1", "starwars", "whatever", "freedom",
+	"secret", "summer", "winter", "spring", "autumn", "hello", "charlie", "michael",
+	"jordan", "hunter", "ranger", "soccer", "hockey", "killer", "george", "pepper",
+	"cheese", "computer", "internet", "samsung", "google", "abc", "love", "test",
+	"user", "root", "pass", "changeme", "default", "guest", "access", "flower",
+	"orange", "banana", "apple", "cookie", "ninja", "mustang", "matrix", "passw0rd",
+}
+
+// leet maps common character substitutions back to letters
+var leet = strings.NewReplacer("0", "o", "1", "l", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s", "!", "i")
+
+var keyboardRows = []string{
+	"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./",
+	"qwertzuiop", "yxcvbnm", "azertyuiop", "qsdfghjklm", "wxcvbn",
+}
+
+// PasswordEntropy estimates the entropy of the password in bits. Like zxcvbn it
+// finds guessable patterns, i.e. common passwords, the words, repeated
+// characters, sequences, keyboard walks and years, and charges the remaining
+// characters by the size of the used character classes.
+func PasswordEntropy(password string, words ...string) float64 {
+	runes := []rune(password)
+	lower := []rune(strings.ToLower(password))
+	if len(lower) != len(runes) {
+		lower = runes
+	}
+	unleet := []rune(leet.Replace(string(lower)))
+	if len(unleet) != len(lower) {
+		unleet = lower
+	}
+
+	cardinality := float64(charsetSize(runes))
+	dictionary := make([][]rune, 0, len(commonPasswords)+len(words))
+	for _, w := range append(append([]string(nil), commonPasswords...), words...) {
+		dictionary = append(dictionary, []rune(w))
+	}
+	runs := newRunLengths(lower)
+
+	// minimum entropy of the prefix runes[:i]
+	best := make([]float64, len(runes)+1)
+	for i := 1; i <= len(runes); i++ {
+		best[i] = math.Inf(1)
+	}
+
+	for i := 0; i < len(runes); i++ {
+		if e := best[i] + math.Log2(cardinality); e < best[i+1] {
+			best[i+1] = e
+		}
+
+		for _, m := range patternsAt(lower, unleet, runes, i, dictionary, runs) {
+			if e := best[i] + m.entropy; e < best[i+m.length] {
+				best[i+m.length] = e
+			}
+		}
+	}
+
+	return best[len(runes)]
+}
+
+type patternMatch struct {
+	length  int
+	entropy float64
+}
+
+// runLengths holds the length of the repeats, sequences and keyboard walks
+// starting at each index. They are computed once from the end, so the runs
+// aren't walked again for every index of long passwords.
+type runLengths struct {
+	repeat     []int
+	ascending  []int
+	descending []int
+	walks      [][]int
+}
+
+func newRunLengths(lower []rune) runLengths {
+	n := len(lower)
+	r := runLengths{
+		repeat:     make([]int, n),
+		ascending:  make([]int, n),
+		descending: make([]int, n),
+		walks:      make([][]int, len(keyboardRows)),
+	}
+	for k := range keyboardRows {
+		r.walks[k] = make([]int, n)
+	}
+
+	for i := n - 1; i >= 0; i-- {
+		r.repeat[i], r.ascending[i], r.descending[i] = 1, 1, 1
+		if i+1 < n {
+			switch lower[i+1] - lower[i] {
+			case 0:
+				r.repeat[i] += r.repeat[i+1]
+			case 1:
+				r.ascending[i] += r.ascending[i+1]
+			case -1:
+				r.descending[i] += r.descending[i+1]
+			}
+		}
+
+		for k, row := range keyboardRows {
+			idx := strings.IndexRune(row, lower[i])
+			if idx < 0 {
+				continue
+			}
+
+			r.walks[k][i] = 1
+			if i+1 < n && strings.IndexRune(row, lower[i+1])-idx == 1 {
+				r.walks[k][i] += r.walks[k][i+1]
+			}
+		}
+	}
+
+	return r
+}
+
+// patternsAt returns the patterns starting at index i
+func patternsAt(lower, unleet, runes []rune, i int, dictionary [][]rune, runs runLengths) []patternMatch {
+	matches := make([]patternMatch, 0)
+
+	// dictionary words, uppercase letters and substitutions double the guesses
+	for rank, wr := range dictionary {
+		if len(wr) < 3 || i+len(wr) > len(lower) {
+			continue
+		}
+
+		plain := equalRunes(lower[i:i+len(wr)], wr)
+		if !plain && !equalRunes(unleet[i:i+len(wr)], wr) {
+			continue
+		}
+
+		e := math.Log2(float64(rank + 1))
+		if !plain {
+			e++
+		}
+		if !equalRunes(runes[i:i+len(wr)], lower[i:i+len(wr)]) {
+			e++
+		}
+		matches = append(matches, patternMatch{len(wr), e})
+	}
+
+	// repeated characters
+	if n := runs.repeat[i]; n >= 3 {
+		matches = append(matches, patternMatch{n, math.Log2(float64(charsetSize(runes[i:i+1]) * n))})
+	}
+
+	// ascending or descending sequences like abc or 987
+	for _, n := range []int{runs.ascending[i], runs.descending[i]} {
+		if n >= 3 {
+			matches = append(matches, patternMatch{n, math.Log2(float64(charsetSize(runes[i:i+1]))) + math.Log2(float64(n)) + 1})
+		}
+	}
+
+	// keyboard walks like qwerty or asdf
+	for k, row := range keyboardRows {
+		if n := runs.walks[k][i]; n >= 4 {
+			matches = append(matches, patternMatch{n, math.Log2(float64(len(keyboardRows)*len(row))) + math.Log2(float64(n))})
+		}
+	}
+
+	// years between 1900 and 2099
+	if i+4 <= len(lower) {
+		y := string(lower[i : i+4])
+		if (strings.HasPrefix(y, "19") || strings.HasPrefix(y, "20")) && strings.Trim(y, "0123456789") == "" {
+			matches = append(matches, patternMatch{4, math.Log2(200)})
+		}
+	}
+
+	return matches
+}
+
+func equalRunes(a, b []rune) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// charsetSize returns the number of characters of the classes used in runes
+func charsetSize(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < 128:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	n := 0
+	if lower {
+		n += 26
+	}
+	if upper {
+		n += 26
+	}
+	if digit {
+		n += 10
+	}
+	if symbol {
+		n += 33
+	}
+	if other {
+		n += 100
+	}
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+/////////////////////////////////// BREACHED PASSWORDS ///////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
+// breached checks the password against the configured breach lists
+func (p *passwordPolicy) breached(password string) (bool, error) {
+	if p.breachFile == "" && p.breachDir == "" {
+		return false, nil
+	}
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	if p.breachDir != "" {
+		found, err := searchRangeDir(p.breachDir, hash)
+		if err != nil || found {
+			return found, err
+		}
+	}
+
+	if p.breachFile != "" {
+		return searchHashFile(p.breachFile, hash)
+	}
+
+	return false, nil
+}
+
+// searchRangeDir looks up the hash in the range file of its prefix
+func searchRangeDir(dir, hash string) (bool, error) {
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(dir, prefix))
+	if os.IsNotExist(err) {
+		f, err = os.Open(filepath.Join(dir, prefix+".txt"))
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if strings.EqualFold(hashOfLine(s.Text()), suffix) {
+			return true, nil
+		}
+	}
+
+	return false, s.Err()
+}
+
+// searchHashFile binary searches the hash in the sorted file without reading
+// it completely, as breach lists are several GB large
+func searchHashFile(path, hash string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	// Invariant: lines starting before lo are smaller than the hash, lines
+	// starting at or after hi are greater
+	lo, hi := int64(0), info.Size()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		start, next, line, err := lineAfter(f, mid, lo)
+		if err != nil {
+			return false, err
+		}
+
+		if start >= hi {
+			// No line starts within [mid, hi), continue in the lower half
+			hi = mid
+			continue
+		}
+
+		switch strings.Compare(strings.ToUpper(hashOfLine(line)), hash) {
+		case 0:
+			return true, nil
+		case -1:
+			lo = next
+		default:
+			hi = start
+		}
+	}
+
+	return false, nil
+}
+
+// lineAfter returns the offset of the first line starting at or after off, the
+// offset of the following line and the line itself. The line starting at lo is
+// returned if off is lo.
+func lineAfter(f *os.File, off, lo int64) (int64, int64, string, error) {
+	start := off
+	if off > lo {
+		// Skip the rest of the line containing off-1
+		_, err := f.Seek(off-1, io.SeekStart)
+		if err != nil {
+			return 0, 0, "", err
+		}
+
+		skipped, err := bufio.NewReader(f).ReadString('\n')
+		if err == io.EOF {
+			end := off - 1 + int64(len(skipped))
+			return end, end, "", nil
+		}
+		if err != nil {
+			return 0, 0, "", err
+		}
+		start = off - 1 + int64(len(skipped))
+	}
+
+	_, err := f.Seek(start, io.SeekStart)
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, 0, "", err
+	}
+
+	return start, start + int64(len(line)), strings.TrimRight(line, "\r\n"), nil
+}
+
+// hashOfLine returns the hash of a "<hash>:<count>" line
+func hashOfLine(line string) string {
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		line = line[:i]
+	}
+	return strings.TrimSpace(line)
+}
@@ -0,0 +1,185 @@
+package goauth
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// writeHashFile writes the sorted SHA-1 hashes of the passwords in the HIBP
+// format and returns the sorted hashes
+func writeHashFile(t *testing.T, dir, newline string, passwords []string) (string, []string) {
+	hashes := make([]string, len(passwords))
+	for i, p := range passwords {
+		hashes[i] = sha1Hex(p)
+	}
+	sort.Strings(hashes)
+
+	var b strings.Builder
+	for i, h := range hashes {
+		// Mix lines with and without count
+		if i%3 == 0 {
+			b.WriteString(h + newline)
+		} else {
+			fmt.Fprintf(&b, "%s:%d%s", h, i+1, newline)
+		}
+	}
+
+	path := filepath.Join(dir, "hashes"+fmt.Sprint(len(newline))+".txt")
+	err := ioutil.WriteFile(path, []byte(b.String()), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, hashes
+}
+
+func TestSearchHashFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	passwords := make([]string, 500)
+	for i := range passwords {
+		passwords[i] = fmt.Sprintf("password%d", i)
+	}
+
+	for _, newline := range []string{"\n", "\r\n"} {
+		path, hashes := writeHashFile(t, dir, newline, passwords)
+
+		t.Run(fmt.Sprintf("%q", newline), func(t *testing.T) {
+			// Every line, including the first and the last, is found
+			for _, h := range hashes {
+				found, err := searchHashFile(path, h)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !found {
+					t.Errorf("searchHashFile(%s) = false, want true", h)
+				}
+			}
+
+			missing := []string{
+				strings.Repeat("0", 40),
+				strings.Repeat("F", 40),
+				sha1Hex("not breached"),
+			}
+			for _, h := range missing {
+				found, err := searchHashFile(path, h)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if found {
+					t.Errorf("searchHashFile(%s) = true, want false", h)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchHashFileSizes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, n := range []int{0, 1, 2, 3} {
+		passwords := make([]string, n)
+		for i := range passwords {
+			passwords[i] = fmt.Sprintf("secret%d", i)
+		}
+
+		path, hashes := writeHashFile(t, dir, "\n", passwords)
+		for _, h := range hashes {
+			found, err := searchHashFile(path, h)
+			if err != nil || !found {
+				t.Errorf("%d lines: searchHashFile(%s) = %v, %v, want true, <nil>", n, h, found, err)
+			}
+		}
+
+		found, err := searchHashFile(path, sha1Hex("not breached"))
+		if err != nil || found {
+			t.Errorf("%d lines: searchHashFile(missing) = %v, %v, want false, <nil>", n, found, err)
+		}
+	}
+}
+
+func TestSearchRangeDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hash := sha1Hex("breached")
+	err = ioutil.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte(strings.ToLower(hash[5:])+":42\r\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		hash string
+		want bool
+	}{
+		{hash, true},
+		{hash[:5] + strings.Repeat("0", 35), false},
+		{sha1Hex("not breached"), false},
+	}
+
+	for _, tt := range tests {
+		found, err := searchRangeDir(dir, tt.hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != tt.want {
+			t.Errorf("searchRangeDir(%s) = %v, want %v", tt.hash, found, tt.want)
+		}
+	}
+}
+
+func TestCheckPasswordTooLong(t *testing.T) {
+	auth := &authenticator{}
+	PasswordPolicy(PasswordBreachFile("does-not-exist"))(auth)
+
+	// The breach file would fail, if it was searched
+	violations, err := auth.CheckPassword(strings.Repeat("a", 1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 1 || violations[0].Code != PasswordTooLong {
+		t.Errorf("violations = %v, want %s", violations, PasswordTooLong)
+	}
+}
+
+func TestPasswordEntropyLongPasswords(t *testing.T) {
+	n := 50000
+	passwords := map[string]string{
+		"repeat":   strings.Repeat("a", n),
+		"sequence": strings.Repeat("abcdefghijklmnopqrstuvwxyz", n/26),
+		"walk":     strings.Repeat("qwertyuiop", n/10),
+	}
+
+	for name, password := range passwords {
+		t.Run(name, func(t *testing.T) {
+			start := time.Now()
+			PasswordEntropy(password)
+			if d := time.Since(start); d > 2*time.Second {
+				t.Errorf("PasswordEntropy took %v for %d characters", d, len(password))
+			}
+		})
+	}
+}
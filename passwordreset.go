@@ -0,0 +1,296 @@
+package goauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ResetToken is a stored password reset token. Only the hash of the token is
+// stored.
+type ResetToken struct {
+	Hash string `json:"hash"`
+
+	// User is the identifier of the user, Lookup the map passed to the lookup
+	// function to find the user again
+	User   string                 `json:"user"`
+	Lookup map[string]interface{} `json:"lookup"`
+
+	// Fingerprint is a keyed hash of the password hash at the time the token was
+	// created. The token is invalid once the password changed.
+	Fingerprint string    `json:"fingerprint"`
+	Created     time.Time `json:"created"`
+	Expires     time.Time `json:"expires"`
+}
+
+// ResetTokenStore stores password reset tokens
+type ResetTokenStore interface {
+	// SaveResetToken stores the token
+	SaveResetToken(ResetToken) error
+
+	// ResetToken returns the token with the hash or ErrorResetTokenInvalid
+	ResetToken(hash string) (ResetToken, error)
+
+	// RemoveResetTokens removes all tokens of the user
+	RemoveResetTokens(user string) error
+}
+
+type passwordReset struct {
+	key   []byte
+	ttl   time.Duration
+	store ResetTokenStore
+
+	mu sync.Mutex
+}
+
+// PasswordReset enables password reset tokens. Tokens are valid for ttl (15
+// minutes by default), can be used once and become invalid as soon as the
+// password of the user changes. The key is used to fingerprint the password
+// hashes, the store defaults to an in-memory store.
+func PasswordReset(key []byte, ttl time.Duration, store ResetTokenStore) AuthenticatorOption {
+	return func(auth *authenticator) {
+		if len(key) < 32 {
+			panic("The password reset key must be at least 32 bytes long")
+		}
+
+		if ttl <= 0 {
+			ttl = 15 * time.Minute
+		}
+
+		if store == nil {
+			store = NewMemoryResetTokenStore()
+		}
+
+		auth.passwordReset = &passwordReset{
+			key:   key,
+			ttl:   ttl,
+			store: store,
+		}
+	}
+}
+
+// CreatePasswordReset looks up the user and returns a new reset token, e.g. to
+// be sent by email. It returns ErrorUserNotFound for unknown users, which
+// shouldn't be revealed in the response.
+func (auth *authenticator) CreatePasswordReset(user interface{}) (string, error) {
+	pr := auth.passwordReset
+	if pr == nil {
+		return "", ErrorPasswordResetDisabled
+	}
+
+	m, err := userMap(user)
+	if err != nil {
+		return "", err
+	}
+
+	stored, err := auth.lookupMethod.Do(m)
+	if err != nil {
+		return "", err
+	}
+
+	if isNil(stored) {
+		return "", ErrorUserNotFound
+	}
+
+	identifier, lookup, err := identifierLookup(stored)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := randomCryptoString(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = pr.store.SaveResetToken(ResetToken{
+		Hash:        hashResetToken(token),
+		User:        identifier,
+		Lookup:      lookup,
+		Fingerprint: pr.fingerprint(stored),
+		Created:     now,
+		Expires:     now.Add(pr.ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// VerifyPasswordReset checks the reset token without using it and returns the
+// stored user, e.g. before showing the reset form
+func (auth *authenticator) VerifyPasswordReset(token string) (interface{}, error) {
+	if auth.passwordReset == nil {
+		return nil, ErrorPasswordResetDisabled
+	}
+
+	stored, _, err := auth.verifyResetToken(token)
+	return stored, err
+}
+
+// ResetPassword uses the reset token and hashes the new password. It returns
+// the stored user and the new hash, which has to be persisted. All reset tokens
+// of the user are revoked and a brute-force lockout of the account is lifted.
+// Check the new password with CheckPassword first.
+func (auth *authenticator) ResetPassword(token, password string) (interface{}, string, error) {
+	pr := auth.passwordReset
+	if pr == nil {
+		return nil, "", ErrorPasswordResetDisabled
+	}
+
+	// Concurrent resets with the same token must not both succeed
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	stored, rt, err := auth.verifyResetToken(token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	hash, err := auth.hasher.Hash(password)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = pr.store.RemoveResetTokens(rt.User)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if auth.lockout != nil {
+		err = auth.lockout.succeed(rt.User, "")
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return stored, hash, nil
+}
+
+// verifyResetToken returns the stored user of a valid reset token
+func (auth *authenticator) verifyResetToken(token string) (interface{}, ResetToken, error) {
+	pr := auth.passwordReset
+	if token == "" {
+		return nil, ResetToken{}, ErrorResetTokenInvalid
+	}
+
+	rt, err := pr.store.ResetToken(hashResetToken(token))
+	if err != nil {
+		return nil, ResetToken{}, err
+	}
+
+	if time.Now().After(rt.Expires) {
+		return nil, ResetToken{}, ErrorResetTokenInvalid
+	}
+
+	stored, err := auth.lookupMethod.Do(rt.Lookup)
+	if err != nil {
+		return nil, ResetToken{}, err
+	}
+
+	if isNil(stored) || !hmac.Equal([]byte(pr.fingerprint(stored)), []byte(rt.Fingerprint)) {
+		return nil, ResetToken{}, ErrorResetTokenInvalid
+	}
+
+	return stored, rt, nil
+}
+
+// fingerprint returns a keyed hash of the password hash of the user
+func (pr *passwordReset) fingerprint(user interface{}) string {
+	hash, _ := getTags(user)["password"].(string)
+
+	mac := hmac.New(sha256.New, pr.key)
+	mac.Write([]byte("password-reset"))
+	mac.Write([]byte{0})
+	mac.Write([]byte(hash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func hashResetToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// identifierLookup returns the identifier of the user and a lookup map which
+// only contains the identifier field
+func identifierLookup(user interface{}) (string, map[string]interface{}, error) {
+	m, err := userMap(user)
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, t := range getDeepTags(reflect.ValueOf(user), true) {
+		if t.Name != "identifier" || t.Key == "" || t.Value == nil {
+			continue
+		}
+
+		if v, ok := m[t.Key]; ok {
+			return fmt.Sprint(t.Value), map[string]interface{}{t.Key: v}, nil
+		}
+	}
+
+	return "", nil, ErrorIdentifierMissing
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////// MEMORY RESET TOKEN STORE ///////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
+// MemoryResetTokenStore is an in-memory ResetTokenStore, e.g. for development
+type MemoryResetTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]ResetToken
+}
+
+// NewMemoryResetTokenStore returns an empty in-memory reset token store
+func NewMemoryResetTokenStore() *MemoryResetTokenStore {
+	return &MemoryResetTokenStore{
+		tokens: make(map[string]ResetToken),
+	}
+}
+
+// SaveResetToken stores the token, expired tokens are removed
+func (s *MemoryResetTokenStore) SaveResetToken(t ResetToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, v := range s.tokens {
+		if now.After(v.Expires) {
+			delete(s.tokens, k)
+		}
+	}
+
+	s.tokens[t.Hash] = t
+	return nil
+}
+
+// ResetToken returns the token with the hash
+func (s *MemoryResetTokenStore) ResetToken(hash string) (ResetToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[hash]
+	if !ok {
+		return ResetToken{}, ErrorResetTokenInvalid
+	}
+	return t, nil
+}
+
+// RemoveResetTokens removes all tokens of the user
+func (s *MemoryResetTokenStore) RemoveResetTokens(user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, v := range s.tokens {
+		if v.User == user {
+			delete(s.tokens, k)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package goauth
+
+import (
+	"sync"
+	"time"
+)
+
+// pendingLogins holds the state of logins which passed the first factor and
+// wait for the 2FA validation
+type pendingLogins struct {
+	lifetime    time.Duration
+	maxAttempts int
+
+	mu     sync.Mutex
+	logins map[string]pendingLogin
+}
+
+type pendingLogin struct {
+	user     map[string]interface{}
+	twoFAMap map[string]interface{}
+	amr      []string
+	expires  time.Time
+	attempts int
+}
+
+// TwoFAPendingLifetime sets how long users have to submit their 2FA code after
+// the first factor, defaults to 5 minutes
+func TwoFAPendingLifetime(lifetime time.Duration) AuthenticatorOption {
+	return func(auth *authenticator) {
+		if lifetime <= 0 {
+			panic("The pending 2FA lifetime must be > 0")
+		}
+		auth.pending.lifetime = lifetime
+	}
+}
+
+// twoFAAttempts limits the failed 2FA codes per 2FA user over all contexts, so
+// codes can't be brute-forced by requesting new tokens
+type twoFAAttempts struct {
+	max    int
+	window time.Duration
+	store  AttemptStore
+
+	mu sync.Mutex
+}
+
+// TwoFAAttempts sets how many failed 2FA codes a user can submit within the
+// window, defaults to 5 within 15 minutes. The attempts are stored in the
+// AttemptStore of BruteForceProtection if enabled.
+func TwoFAAttempts(max int, window time.Duration) AuthenticatorOption {
+	return func(auth *authenticator) {
+		if max <= 0 || window <= 0 {
+			panic("Invalid 2FA attempts limit")
+		}
+		auth.twoFAAttempts.max = max
+		auth.twoFAAttempts.window = window
+	}
+}
+
+func newPendingLogins() *pendingLogins {
+	return &pendingLogins{
+		lifetime:    5 * time.Minute,
+		maxAttempts: 5,
+		logins:      make(map[string]pendingLogin),
+	}
+}
+
+// add stores the state of the context and returns its ID
+func (p *pendingLogins) add(c *context) (string, time.Time, error) {
+	id, err := randomCryptoString(20)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for k, l := range p.logins {
+		if now.After(l.expires) {
+			delete(p.logins, k)
+		}
+	}
+
+	expires := now.Add(p.lifetime)
+	p.logins[id] = pendingLogin{
+		user:     c.user,
+		twoFAMap: c.twoFAMap,
+		amr:      c.amr,
+		expires:  expires,
+	}
+
+	return id, expires, nil
+}
+
+func (p *pendingLogins) get(id string) (pendingLogin, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	l, ok := p.logins[id]
+	if !ok || time.Now().After(l.expires) {
+		delete(p.logins, id)
+		return pendingLogin{}, false
+	}
+
+	return l, true
+}
+
+// fail counts a failed 2FA validation and discards the login after too many
+func (p *pendingLogins) fail(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	l, ok := p.logins[id]
+	if !ok {
+		return
+	}
+
+	l.attempts++
+	if l.attempts >= p.maxAttempts {
+		delete(p.logins, id)
+		return
+	}
+
+	p.logins[id] = l
+}
+
+func (p *pendingLogins) remove(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.logins, id)
+}
+
+func newTwoFAAttempts() *twoFAAttempts {
+	return &twoFAAttempts{
+		max:    5,
+		window: 15 * time.Minute,
+		store:  NewMemoryAttemptStore(),
+	}
+}
+
+// take counts an attempt of the key as failed until succeed is called. Taking
+// the attempt before the code is validated keeps concurrent requests from
+// exceeding the limit.
+func (a *twoFAAttempts) take(key string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	at, err := a.store.Attempts(key)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if now.Sub(at.LastFailure) > a.window {
+		at.Failures = 0
+	}
+
+	if at.Failures >= a.max {
+		return Error2FATooManyAttempts
+	}
+
+	at.Failures++
+	at.LastFailure = now
+	return a.store.SaveAttempts(key, at, a.window)
+}
+
+func (a *twoFAAttempts) succeed(key string) error {
+	return a.store.ResetAttempts(key)
+}
@@ -0,0 +1,515 @@
+package goauth
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labstack/echo"
+)
+
+// Effect is the effect of a matching rule
+type Effect string
+
+// Rule effects. Deny rules take precedence over allow rules.
+const (
+	EffectAllow Effect = "allow"
+	EffectDeny  Effect = "deny"
+)
+
+// AccessRequest holds the attributes a policy is evaluated against
+type AccessRequest struct {
+	// Claims of the validated token
+	Claims map[string]interface{}
+
+	Method string
+	Path   string
+
+	// Params are the route parameters, never filled from the query
+	Params map[string]string
+
+	// Query holds the query parameters, which are controlled by the client
+	Query url.Values
+
+	// Resource holds the attributes of the accessed resource, supplied by the
+	// ResourceFunc or the handler
+	Resource map[string]interface{}
+}
+
+// Condition is a described predicate of a rule
+type Condition struct {
+	Description string
+	Match       func(*AccessRequest) bool
+}
+
+// Rule allows or denies requests which match all its methods, paths and
+// conditions. Empty methods and paths match all requests.
+type Rule struct {
+	Name       string
+	Effect     Effect
+	Methods    []string
+	Paths      []string
+	Conditions []Condition
+}
+
+// RuleResult explains whether a rule matched the request
+type RuleResult struct {
+	Rule    string `json:"rule"`
+	Effect  Effect `json:"effect"`
+	Matched bool   `json:"matched"`
+	Reason  string `json:"reason"`
+}
+
+// Decision is the explained result of a policy evaluation
+type Decision struct {
+	Allowed bool `json:"allowed"`
+
+	// Rule is the name of the deciding rule, empty if no rule matched
+	Rule   string `json:"rule,omitempty"`
+	Reason string `json:"reason"`
+
+	// Trace lists the results of all rules in order
+	Trace []RuleResult `json:"trace"`
+}
+
+// Policy is a set of rules. Requests are denied if a deny rule matches, allowed
+// if an allow rule matches and denied by default.
+type Policy struct {
+	rules   []Rule
+	explain func(*AccessRequest, Decision)
+}
+
+// ParamFunc returns the route parameters of the request, e.g. mux.Vars of
+// gorilla/mux
+type ParamFunc func(r *http.Request) map[string]string
+
+// ResourceFunc returns the attributes of the resource of the request, e.g.
+// loaded from the database by the ID param. Return ErrorResourceNotFound for
+// unknown resources.
+type ResourceFunc func(r *http.Request, params map[string]string) (map[string]interface{}, error)
+
+// NewPolicy returns a policy of the rules
+func NewPolicy(rules ...Rule) *Policy {
+	for _, r := range rules {
+		if r.Name == "" {
+			panic("Policy rules need a name")
+		}
+
+		if r.Effect != EffectAllow && r.Effect != EffectDeny {
+			panic("Policy rules need an allow or deny effect")
+		}
+
+		for _, c := range r.Conditions {
+			if c.Match == nil {
+				panic("Policy conditions need a match func")
+			}
+		}
+	}
+
+	return &Policy{rules: rules}
+}
+
+// Explain sets a function receiving every decision of the policy, e.g. to log
+// why requests were denied while debugging
+func (p *Policy) Explain(f func(*AccessRequest, Decision)) *Policy {
+	p.explain = f
+	return p
+}
+
+// Allow returns a rule allowing requests which meet all conditions
+func Allow(name string, conditions ...Condition) Rule {
+	return Rule{Name: name, Effect: EffectAllow, Conditions: conditions}
+}
+
+// Deny returns a rule denying requests which meet all conditions
+func Deny(name string, conditions ...Condition) Rule {
+	return Rule{Name: name, Effect: EffectDeny, Conditions: conditions}
+}
+
+// On restricts the rule to the HTTP methods
+func (r Rule) On(methods ...string) Rule {
+	r.Methods = append(append([]string(nil), r.Methods...), methods...)
+	return r
+}
+
+// At restricts the rule to the path patterns, see path.Match, e.g.
+// "/tenants/*/documents". A pattern ending in "/**" also matches all paths
+// below, e.g. "/admin/**" matches "/admin" and "/admin/users/1". Paths are
+// cleaned before matching, so "/admin//users/" matches like "/admin/users".
+func (r Rule) At(paths ...string) Rule {
+	r.Paths = append(append([]string(nil), r.Paths...), paths...)
+	return r
+}
+
+// Evaluate evaluates all rules against the request
+func (p *Policy) Evaluate(req *AccessRequest) Decision {
+	d := Decision{
+		Reason: "no rule allows the request",
+		Trace:  make([]RuleResult, 0, len(p.rules)),
+	}
+
+	// All rules are evaluated for the trace. The first matching deny rule
+	// decides, otherwise the first matching allow rule.
+	var allow, deny string
+	for _, r := range p.rules {
+		res := r.evaluate(req)
+		d.Trace = append(d.Trace, res)
+		if !res.Matched {
+			continue
+		}
+
+		if r.Effect == EffectDeny && deny == "" {
+			deny = r.Name
+		}
+		if r.Effect == EffectAllow && allow == "" {
+			allow = r.Name
+		}
+	}
+
+	switch {
+	case deny != "":
+		d.Rule = deny
+		d.Reason = fmt.Sprintf("denied by rule %q", deny)
+	case allow != "":
+		d.Allowed = true
+		d.Rule = allow
+		d.Reason = fmt.Sprintf("allowed by rule %q", allow)
+	}
+
+	if p.explain != nil {
+		p.explain(req, d)
+	}
+
+	return d
+}
+
+// String explains the decision and the result of each rule
+func (d Decision) String() string {
+	var b strings.Builder
+	b.WriteString(d.Reason)
+	for _, r := range d.Trace {
+		match := "no match"
+		if r.Matched {
+			match = "match"
+		}
+		fmt.Fprintf(&b, "\n  %s %q: %s, %s", r.Effect, r.Rule, match, r.Reason)
+	}
+	return b.String()
+}
+
+func (r Rule) evaluate(req *AccessRequest) RuleResult {
+	res := RuleResult{Rule: r.Name, Effect: r.Effect}
+
+	if len(r.Methods) > 0 && !containsFold(r.Methods, req.Method) {
+		res.Reason = fmt.Sprintf("method %s not in %v", req.Method, r.Methods)
+		return res
+	}
+
+	if len(r.Paths) > 0 && !matchPath(r.Paths, req.Path) {
+		res.Reason = fmt.Sprintf("path %s does not match %v", req.Path, r.Paths)
+		return res
+	}
+
+	for _, c := range r.Conditions {
+		if !c.Match(req) {
+			res.Reason = "condition not met: " + c.Description
+			return res
+		}
+	}
+
+	res.Matched = true
+	res.Reason = "all conditions met"
+	return res
+}
+
+func containsFold(s []string, v string) bool {
+	for _, e := range s {
+		if strings.EqualFold(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath matches the cleaned path against the patterns. Patterns ending in
+// "/**" match the prefix and all paths below.
+func matchPath(patterns []string, p string) bool {
+	p = path.Clean("/" + p)
+	for _, pattern := range patterns {
+		if prefix := strings.TrimSuffix(pattern, "/**"); prefix != pattern {
+			if matchPrefix(prefix, p) {
+				return true
+			}
+			continue
+		}
+
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPrefix reports whether the path or one of its parents matches the prefix
+func matchPrefix(prefix, p string) bool {
+	if prefix == "" {
+		return true
+	}
+
+	for i := len(p); i > 0; i = strings.LastIndexByte(p[:i], '/') {
+		if ok, _ := path.Match(prefix, p[:i]); ok {
+			return true
+		}
+	}
+	return false
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////// CONDITIONS /////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
+// When returns a custom condition
+func When(description string, match func(*AccessRequest) bool) Condition {
+	return Condition{Description: description, Match: match}
+}
+
+// Not negates the condition
+func Not(c Condition) Condition {
+	return Condition{
+		Description: "not " + c.Description,
+		Match: func(req *AccessRequest) bool {
+			return !c.Match(req)
+		},
+	}
+}
+
+// ClaimEquals requires the claim to equal the value
+func ClaimEquals(claim string, value interface{}) Condition {
+	return Condition{
+		Description: fmt.Sprintf("claim %s equals %v", claim, value),
+		Match: func(req *AccessRequest) bool {
+			v, ok := attribute(req.Claims, claim)
+			return ok && equalValues(v, value)
+		},
+	}
+}
+
+// ClaimContains requires the array or space-delimited claim to contain the
+// value, e.g. a role or scope
+func ClaimContains(claim, value string) Condition {
+	return Condition{
+		Description: fmt.Sprintf("claim %s contains %s", claim, value),
+		Match: func(req *AccessRequest) bool {
+			return containsString(claimValues(req.Claims, claim), value)
+		},
+	}
+}
+
+// ClaimMatchesParam requires the claim to equal the param, e.g. to restrict
+// users to the tenant of the route
+func ClaimMatchesParam(claim, param string) Condition {
+	return Condition{
+		Description: fmt.Sprintf("claim %s matches param %s", claim, param),
+		Match: func(req *AccessRequest) bool {
+			v, ok := attribute(req.Claims, claim)
+			p, found := req.Params[param]
+			return ok && found && equalValues(v, p)
+		},
+	}
+}
+
+// ClaimMatchesResource requires the claim to equal the resource attribute, e.g.
+// to restrict users to the resources they own
+func ClaimMatchesResource(claim, attr string) Condition {
+	return Condition{
+		Description: fmt.Sprintf("claim %s matches resource %s", claim, attr),
+		Match: func(req *AccessRequest) bool {
+			v, ok := attribute(req.Claims, claim)
+			r, found := attribute(req.Resource, attr)
+			return ok && found && equalValues(v, r)
+		},
+	}
+}
+
+// ParamEquals requires the param to equal the value
+func ParamEquals(param, value string) Condition {
+	return Condition{
+		Description: fmt.Sprintf("param %s equals %s", param, value),
+		Match: func(req *AccessRequest) bool {
+			p, ok := req.Params[param]
+			return ok && p == value
+		},
+	}
+}
+
+// ResourceEquals requires the resource attribute to equal the value
+func ResourceEquals(attr string, value interface{}) Condition {
+	return Condition{
+		Description: fmt.Sprintf("resource %s equals %v", attr, value),
+		Match: func(req *AccessRequest) bool {
+			v, ok := attribute(req.Resource, attr)
+			return ok && equalValues(v, value)
+		},
+	}
+}
+
+// equalValues compares values by their string representation, as numbers of
+// decoded claims are float64
+func equalValues(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////// MIDDLEWARES ////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
+// Authorize evaluates the policy against the token of the request, the route
+// params and the resource attributes, e.g. inside a handler after loading the
+// resource
+func (auth *authenticator) Authorize(p *Policy, r *http.Request, params map[string]string, resource map[string]interface{}) (Decision, error) {
+	t, err := auth.lookupToken(r)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	claims, err := auth.validClaims(t)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	return p.Evaluate(&AccessRequest{
+		Claims:   claims,
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Params:   routeParams(params),
+		Query:    r.URL.Query(),
+		Resource: resource,
+	}), nil
+}
+
+// PolicyMiddleware provides a middleware func for the net/http to protect
+// routes with the policy. net/http has no route params, so they are extracted
+// by the param func of your router. The resource func may be nil.
+func (auth *authenticator) PolicyMiddleware(p *Policy, params ParamFunc, resource ResourceFunc) func(http.Handler) http.Handler {
+	if params == nil {
+		panic("The param func cannot be nil")
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			code, body := auth.enforce(p, resource, r, params(r))
+			if code != http.StatusOK {
+				if code == http.StatusUnauthorized && auth.redirect {
+					auth.redirectTo(w, r, auth.redirectTarget)
+					return
+				}
+				auth.json(w, code, body)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// EchoPolicyMiddleware provides a policy middleware func for the echo framework
+func (auth *authenticator) EchoPolicyMiddleware(p *Policy, resource ResourceFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			params := make(map[string]string)
+			values := c.ParamValues()
+			for i, name := range c.ParamNames() {
+				if i < len(values) {
+					params[name] = values[i]
+				}
+			}
+
+			code, body := auth.enforce(p, resource, c.Request(), params)
+			if code != http.StatusOK {
+				if code == http.StatusUnauthorized && auth.redirect {
+					return c.Redirect(http.StatusMovedPermanently, auth.redirectTarget)
+				}
+				return c.JSON(code, body)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// GinPolicyMiddleware provides a policy middleware func for the gin framework
+func (auth *authenticator) GinPolicyMiddleware(p *Policy, resource ResourceFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		params := make(map[string]string)
+		for _, param := range c.Params {
+			params[param.Key] = param.Value
+		}
+
+		code, body := auth.enforce(p, resource, c.Request, params)
+		if code != http.StatusOK {
+			if code == http.StatusUnauthorized && auth.redirect {
+				c.Redirect(http.StatusMovedPermanently, auth.redirectTarget)
+				c.Abort()
+				return
+			}
+			c.AbortWithStatusJSON(code, body)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// enforce evaluates the policy and returns http.StatusOK if the request is
+// allowed, otherwise the status code and body of the response
+func (auth *authenticator) enforce(p *Policy, resource ResourceFunc, r *http.Request, params map[string]string) (int, map[string]interface{}) {
+	t, err := auth.lookupToken(r)
+	if err != nil {
+		return http.StatusInternalServerError, ErrorKeyLookup(err)
+	}
+
+	claims, err := auth.validClaims(t)
+	if err != nil {
+		return http.StatusUnauthorized, StatusUnauthorized(err)
+	}
+
+	params = routeParams(params)
+	var attrs map[string]interface{}
+	if resource != nil {
+		attrs, err = resource(r, params)
+		if err == ErrorResourceNotFound {
+			return http.StatusNotFound, StatusError(http.StatusNotFound, err)
+		}
+		if err != nil {
+			return http.StatusInternalServerError, StatusError(http.StatusInternalServerError, err)
+		}
+	}
+
+	d := p.Evaluate(&AccessRequest{
+		Claims:   claims,
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Params:   params,
+		Query:    r.URL.Query(),
+		Resource: attrs,
+	})
+	if !d.Allowed {
+		return http.StatusForbidden, StatusForbidden(ErrorPolicyDenied)
+	}
+
+	return http.StatusOK, nil
+}
+
+// routeParams returns the route params, nil params are empty
+func routeParams(params map[string]string) map[string]string {
+	if params == nil {
+		return make(map[string]string)
+	}
+	return params
+}
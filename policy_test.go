@@ -0,0 +1,72 @@
+package goauth
+
+import "testing"
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/admin/*", "/admin/x", true},
+		{"/admin/*", "/admin/x/", true},
+		{"/admin/*", "/admin//x", true},
+		{"/admin/*", "//admin/x", true},
+		{"/admin/*", "/admin/./x", true},
+		{"/admin/*", "/public/../admin/x", true},
+		{"/admin/*", "admin/x", true},
+		{"/admin/*", "/admin/x/y", false},
+		{"/admin/*", "/admin", false},
+		{"/admin/*", "/administrator/x", false},
+
+		{"/admin/**", "/admin", true},
+		{"/admin/**", "/admin/", true},
+		{"/admin/**", "/admin/x", true},
+		{"/admin/**", "/admin/x/y", true},
+		{"/admin/**", "//admin//x/y/", true},
+		{"/admin/**", "/administrator", false},
+		{"/admin/**", "/public/admin/x", false},
+		{"/admin/**", "/admin/../public", false},
+
+		{"/tenants/*/**", "/tenants/a/documents/1", true},
+		{"/tenants/*/**", "/tenants/a", true},
+		{"/tenants/*/**", "/tenants", false},
+		{"/**", "/", true},
+		{"/**", "/any/path", true},
+	}
+
+	for _, tt := range tests {
+		if got := matchPath([]string{tt.pattern}, tt.path); got != tt.want {
+			t.Errorf("matchPath(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyDenyPathBypass(t *testing.T) {
+	always := When("always", func(*AccessRequest) bool { return true })
+	p := NewPolicy(
+		Allow("everyone", always),
+		Deny("admin area", always).At("/admin/*"),
+		Deny("internal area", always).At("/internal/**"),
+	)
+
+	tests := []struct {
+		path    string
+		allowed bool
+	}{
+		{"/admin/x", false},
+		{"/admin/x/", false},
+		{"/admin//x", false},
+		{"//admin/x", false},
+		{"/internal", false},
+		{"/internal/x/y", false},
+		{"/public/x", true},
+	}
+
+	for _, tt := range tests {
+		d := p.Evaluate(&AccessRequest{Method: "GET", Path: tt.path})
+		if d.Allowed != tt.allowed {
+			t.Errorf("%s: allowed = %v, want %v (%s)", tt.path, d.Allowed, tt.allowed, d)
+		}
+	}
+}
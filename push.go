@@ -0,0 +1,375 @@
+package goauth
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PushStatus is the status of a push approval request
+type PushStatus string
+
+// Push approval request statuses
+const (
+	PushPending  PushStatus = "pending"
+	PushApproved PushStatus = "approved"
+	PushDenied   PushStatus = "denied"
+	PushExpired  PushStatus = "expired"
+)
+
+// PushRequest is a login waiting for the approval of the user
+type PushRequest struct {
+	ID   string `json:"id"`
+	User string `json:"user"`
+
+	// Number is shown on the login screen. To approve, the user has to pick it
+	// from the Choices on their device, which prevents approving logins by
+	// accident (push fatigue). It is empty in requests passed to the notifier.
+	Number  string   `json:"-"`
+	Choices []string `json:"choices"`
+
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// Notifier delivers push approval requests to the devices of the user, e.g. via
+// a push service or webhook
+type Notifier interface {
+	Notify(PushRequest) error
+}
+
+// Push is a 2FA method where users approve logins from another authenticated
+// session, e.g. a mobile app
+type Push struct {
+	ttl      time.Duration
+	notifier Notifier
+
+	mu       sync.Mutex
+	requests map[string]*pushRequest
+}
+
+type pushRequest struct {
+	PushRequest
+	status PushStatus
+	done   chan struct{}
+}
+
+// PushApproval registers push approvals as a 2FA method. Requests are delivered
+// via the notifier and expire after ttl.
+func PushApproval(notifier Notifier, ttl time.Duration) AuthenticatorOption {
+	return func(auth *authenticator) {
+		auth.twoFaMethods["push"] = newPush(notifier, ttl)
+	}
+}
+
+func newPush(notifier Notifier, ttl time.Duration) *Push {
+	if notifier == nil {
+		panic("Notifier cannot be nil")
+	}
+
+	if ttl <= 0 {
+		ttl = 2 * time.Minute
+	}
+
+	return &Push{
+		ttl:      ttl,
+		notifier: notifier,
+		requests: make(map[string]*pushRequest),
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////// 2FA METHOD //////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
+// Generate creates an approval request for the user and delivers it. It returns
+// the number to show on the login screen. A previous request of the user is
+// denied.
+func (p *Push) Generate(user string) (string, error) {
+	id, err := randomCryptoString(15)
+	if err != nil {
+		return "", err
+	}
+
+	choices, number, err := pushChoices(3)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	r := &pushRequest{
+		PushRequest: PushRequest{
+			ID:      strings.ToLower(id),
+			User:    user,
+			Number:  number,
+			Choices: choices,
+			Created: now,
+			Expires: now.Add(p.ttl),
+		},
+		status: PushPending,
+		done:   make(chan struct{}),
+	}
+
+	p.mu.Lock()
+	for k, old := range p.requests {
+		if old.User == user || now.After(old.Expires) {
+			p.decide(old, PushDenied)
+			delete(p.requests, k)
+		}
+	}
+	p.requests[r.ID] = r
+	p.mu.Unlock()
+
+	err = p.notifier.Notify(r.public())
+	if err != nil {
+		p.mu.Lock()
+		delete(p.requests, r.ID)
+		p.mu.Unlock()
+		return "", err
+	}
+
+	return number, nil
+}
+
+// Register returns a random secret. Push approvals don't use a secret, it only
+// marks the user as enrolled.
+func (p *Push) Register(account string) (string, string, error) {
+	secret, err := randomCryptoString(20)
+	return secret, "", err
+}
+
+// Secret returns random bytes
+func (p *Push) Secret() ([]byte, error) {
+	return randomCryptoBytes(20)
+}
+
+// Secretless reports that push approvals don't use a 2FA secret
+func (p *Push) Secretless() bool {
+	return true
+}
+
+// Validate always fails, as the request is looked up by the user. Use
+// ValidateUser instead.
+func (p *Push) Validate(code, secret string) bool {
+	return false
+}
+
+// ValidateUser reports whether the request of the user showing the number (code)
+// was approved. The request is consumed once it was decided. Poll with Wait
+// before, as pending requests count as failed validation.
+func (p *Push) ValidateUser(user, code, secret string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id, r := range p.requests {
+		if r.User != user || r.Number != code {
+			continue
+		}
+
+		if r.status == PushPending && !time.Now().After(r.Expires) {
+			return false
+		}
+
+		delete(p.requests, id)
+		return r.status == PushApproved
+	}
+
+	return false
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////// APPROVAL ///////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
+// Pending returns the pending requests of the user, e.g. to show them in the
+// app after a notification got lost
+func (p *Push) Pending(user string) []PushRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	requests := make([]PushRequest, 0)
+	for _, r := range p.requests {
+		if r.User == user && r.status == PushPending && !time.Now().After(r.Expires) {
+			requests = append(requests, r.public())
+		}
+	}
+	return requests
+}
+
+// Approve approves the request of the user with the number the user picked. If
+// the number doesn't match the one on the login screen, the request is denied.
+// Call it from an authenticated session of the user only.
+func (p *Push) Approve(user, id, number string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	r, err := p.pending(user, id)
+	if err != nil {
+		return err
+	}
+
+	if r.Number != number {
+		p.decide(r, PushDenied)
+		return ErrorPushNumberMismatch
+	}
+
+	p.decide(r, PushApproved)
+	return nil
+}
+
+// Deny denies the request of the user
+func (p *Push) Deny(user, id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	r, err := p.pending(user, id)
+	if err != nil {
+		return err
+	}
+
+	p.decide(r, PushDenied)
+	return nil
+}
+
+// Wait waits up to timeout for the decision on the request of the user showing
+// the number and returns its status. A timeout of 0 polls the current status,
+// longer timeouts allow long polling.
+func (p *Push) Wait(user, number string, timeout time.Duration) (PushStatus, error) {
+	p.mu.Lock()
+	var r *pushRequest
+	for _, req := range p.requests {
+		if req.User == user && req.Number == number {
+			r = req
+			break
+		}
+	}
+	p.mu.Unlock()
+
+	if r == nil {
+		return "", ErrorPushRequestNotFound
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	expiry := time.NewTimer(time.Until(r.Expires))
+	defer expiry.Stop()
+
+	select {
+	case <-r.done:
+	case <-timer.C:
+	case <-expiry.C:
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if r.status == PushPending && time.Now().After(r.Expires) {
+		p.decide(r, PushExpired)
+	}
+	return r.status, nil
+}
+
+// public returns the request without the number shown on the login screen
+func (r *pushRequest) public() PushRequest {
+	pub := r.PushRequest
+	pub.Number = ""
+	pub.Choices = append([]string(nil), r.Choices...)
+	return pub
+}
+
+// pending returns the pending request. The lock must be held.
+func (p *Push) pending(user, id string) (*pushRequest, error) {
+	r, ok := p.requests[id]
+	if !ok || r.User != user {
+		return nil, ErrorPushRequestNotFound
+	}
+
+	if time.Now().After(r.Expires) {
+		p.decide(r, PushExpired)
+	}
+
+	if r.status != PushPending {
+		return nil, ErrorPushRequestDecided
+	}
+
+	return r, nil
+}
+
+// decide sets the final status of a pending request and wakes up waiters. The
+// lock must be held.
+func (p *Push) decide(r *pushRequest, status PushStatus) {
+	if r.status != PushPending {
+		return
+	}
+
+	r.status = status
+	close(r.done)
+}
+
+// pushChoices returns n distinct two digit numbers and the correct one among them
+func pushChoices(n int) ([]string, string, error) {
+	choices := make([]string, 0, n)
+	for len(choices) < n {
+		i, err := rand.Int(rand.Reader, big.NewInt(90))
+		if err != nil {
+			return nil, "", err
+		}
+
+		c := strconv.FormatInt(i.Int64()+10, 10)
+		if !containsString(choices, c) {
+			choices = append(choices, c)
+		}
+	}
+
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return choices, choices[i.Int64()], nil
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////// MEMORY NOTIFIER ////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
+// MemoryNotifier records push approval requests in memory, e.g. for tests
+type MemoryNotifier struct {
+	mu       sync.Mutex
+	requests []PushRequest
+}
+
+// Notify records the request
+func (n *MemoryNotifier) Notify(r PushRequest) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.requests = append(n.requests, r)
+	return nil
+}
+
+// Requests returns all recorded requests
+func (n *MemoryNotifier) Requests() []PushRequest {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]PushRequest(nil), n.requests...)
+}
+
+// Last returns the last request for the user
+func (n *MemoryNotifier) Last(user string) (PushRequest, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for i := len(n.requests) - 1; i >= 0; i-- {
+		if n.requests[i].User == user {
+			return n.requests[i], true
+		}
+	}
+
+	return PushRequest{}, false
+}
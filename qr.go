@@ -0,0 +1,94 @@
+package goauth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+
+	"github.com/boombuler/barcode/qr"
+)
+
+// qrQuietZone is the number of light modules around the QR code
+const qrQuietZone = 4
+
+// QRCode is a rendered QR code
+type QRCode struct {
+	// PNG is the PNG encoded image
+	PNG []byte
+
+	// SVG is the SVG document
+	SVG string
+
+	// DataURI is the PNG as data: URI, which can be used as src of an <img> tag
+	DataURI string
+}
+
+// NewQRCode renders the content as QR code with about size x size pixels. The
+// level is the error correction level: L, M, Q or H.
+func NewQRCode(content string, size int, level string) (*QRCode, error) {
+	var l qr.ErrorCorrectionLevel
+	switch level {
+	case "L":
+		l = qr.L
+	case "M":
+		l = qr.M
+	case "Q":
+		l = qr.Q
+	case "H":
+		l = qr.H
+	default:
+		return nil, ErrorQRCodeLevel
+	}
+
+	code, err := qr.Encode(content, l, qr.Auto)
+	if err != nil {
+		return nil, err
+	}
+
+	modules := code.Bounds().Dx()
+	total := modules + 2*qrQuietZone
+	scale := size / total
+	if scale < 1 {
+		scale = 1
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, total*scale, total*scale), color.Palette{color.White, color.Black})
+
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		total*scale, total*scale, total, total)
+	fmt.Fprintf(&svg, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, total, total)
+
+	for y := 0; y < modules; y++ {
+		for x := 0; x < modules; x++ {
+			r, _, _, _ := code.At(x, y).RGBA()
+			if r != 0 {
+				continue
+			}
+
+			fmt.Fprintf(&svg, "M%d %dh1v1h-1z", x+qrQuietZone, y+qrQuietZone)
+			for py := 0; py < scale; py++ {
+				for px := 0; px < scale; px++ {
+					img.SetColorIndex((x+qrQuietZone)*scale+px, (y+qrQuietZone)*scale+py, 1)
+				}
+			}
+		}
+	}
+	svg.WriteString(`"/></svg>`)
+
+	var buf bytes.Buffer
+	err = png.Encode(&buf, img)
+	if err != nil {
+		return nil, err
+	}
+
+	return &QRCode{
+		PNG:     buf.Bytes(),
+		SVG:     svg.String(),
+		DataURI: "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
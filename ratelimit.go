@@ -0,0 +1,370 @@
+package goauth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labstack/echo"
+)
+
+// RateLimitResult is the state of a rate limit bucket after taking a token
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+
+	// Reset is the time until the bucket is full again
+	Reset time.Duration
+
+	// RetryAfter is the time until the next token is available, if not allowed
+	RetryAfter time.Duration
+}
+
+// RateLimitStore stores rate limit buckets, e.g. in Redis to share them between
+// replicas
+type RateLimitStore interface {
+	// Take takes a token from the bucket of the key. The bucket holds limit
+	// tokens and is refilled completely within window.
+	Take(key string, limit int, window time.Duration) (RateLimitResult, error)
+}
+
+// RateLimitOption represents a rate limit option
+type RateLimitOption func(rl *rateLimit)
+
+type rateLimit struct {
+	auth   *authenticator
+	name   string
+	limit  int
+	window time.Duration
+	keys   []rateLimitKey
+	store  RateLimitStore
+}
+
+type rateLimitKey struct {
+	kind string
+	key  func(*http.Request) string
+}
+
+// defaultRateLimitStore is shared by all rate limits without a store
+var defaultRateLimitStore = NewMemoryRateLimitStore()
+
+// rateLimitCount numbers the rate limits for their default names
+var rateLimitCount uint64
+
+// RateLimitByIP limits the requests per client IP. It is the default if no
+// other key is set.
+func RateLimitByIP() RateLimitOption {
+	return func(rl *rateLimit) {
+		rl.keys = append(rl.keys, rateLimitKey{"ip", clientIP})
+	}
+}
+
+// RateLimitByUsername limits the requests per username, which is read from the
+// form or JSON body field, e.g. to limit login attempts per account
+func RateLimitByUsername(field string) RateLimitOption {
+	return func(rl *rateLimit) {
+		rl.keys = append(rl.keys, rateLimitKey{"user", func(r *http.Request) string {
+			return bodyField(r, field)
+		}})
+	}
+}
+
+// RateLimitBySubject limits the requests per authenticated user, identified by
+// the claim of the token, e.g. "user.Username" for the identifier in the "user"
+// claim of goauth tokens or "sub" for tokens of other issuers. Nested claims are
+// separated by dots. If the claim is empty, the whole "user" claim is used.
+// Requests without valid token are limited by IP.
+func RateLimitBySubject(claim string) RateLimitOption {
+	if claim == "" {
+		claim = "user"
+	}
+
+	return func(rl *rateLimit) {
+		rl.keys = append(rl.keys, rateLimitKey{"sub", func(r *http.Request) string {
+			t, err := rl.auth.lookupToken(r)
+			if err != nil {
+				return "ip:" + clientIP(r)
+			}
+
+			claims, err := rl.auth.validClaims(t)
+			if err != nil {
+				return "ip:" + clientIP(r)
+			}
+
+			sub := subject(claims, claim)
+			if sub == "" {
+				return "ip:" + clientIP(r)
+			}
+			return sub
+		}})
+	}
+}
+
+// subject returns the value of the claim as string, objects are JSON encoded
+func subject(claims map[string]interface{}, claim string) string {
+	v, _ := attribute(claims, claim)
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		// Maps are encoded with sorted keys, so the key is stable
+		b, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return string(b)
+	}
+}
+
+// RateLimitName names the buckets of the rate limit. By default each rate limit
+// gets its own buckets, named in the order the rate limits are created. Set a
+// name to share buckets between rate limits or to keep the names stable when
+// sharing a store between replicas.
+func RateLimitName(name string) RateLimitOption {
+	return func(rl *rateLimit) {
+		rl.name = name
+	}
+}
+
+// RateLimitStorage sets the store of the buckets, by default a process wide
+// in-memory store
+func RateLimitStorage(store RateLimitStore) RateLimitOption {
+	return func(rl *rateLimit) {
+		rl.store = store
+	}
+}
+
+func (auth *authenticator) newRateLimit(limit int, window time.Duration, options []RateLimitOption) *rateLimit {
+	if limit <= 0 || window <= 0 {
+		panic("The rate limit and window must be > 0")
+	}
+
+	rl := &rateLimit{
+		auth:   auth,
+		name:   fmt.Sprintf("%d/%s#%d", limit, window, atomic.AddUint64(&rateLimitCount, 1)),
+		limit:  limit,
+		window: window,
+		store:  defaultRateLimitStore,
+	}
+
+	for _, f := range options {
+		f(rl)
+	}
+
+	if len(rl.keys) == 0 {
+		RateLimitByIP()(rl)
+	}
+
+	return rl
+}
+
+// RateLimit provides a middleware func for the net/http to limit the requests
+// to limit per window, e.g. for login and 2FA routes
+func (auth *authenticator) RateLimit(limit int, window time.Duration, options ...RateLimitOption) func(http.Handler) http.Handler {
+	rl := auth.newRateLimit(limit, window, options)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res := rl.take(r)
+			rl.setHeaders(w.Header(), res)
+			if !res.Allowed {
+				auth.json(w, http.StatusTooManyRequests, StatusTooManyRequests(res.RetryAfter))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// EchoRateLimit provides a rate limit middleware func for the echo framework
+func (auth *authenticator) EchoRateLimit(limit int, window time.Duration, options ...RateLimitOption) echo.MiddlewareFunc {
+	rl := auth.newRateLimit(limit, window, options)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			res := rl.take(c.Request())
+			rl.setHeaders(c.Response().Header(), res)
+			if !res.Allowed {
+				return c.JSON(http.StatusTooManyRequests, StatusTooManyRequests(res.RetryAfter))
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// GinRateLimit provides a rate limit middleware func for the gin framework
+func (auth *authenticator) GinRateLimit(limit int, window time.Duration, options ...RateLimitOption) gin.HandlerFunc {
+	rl := auth.newRateLimit(limit, window, options)
+	return func(c *gin.Context) {
+		res := rl.take(c.Request)
+		rl.setHeaders(c.Writer.Header(), res)
+		if !res.Allowed {
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, StatusTooManyRequests(res.RetryAfter))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// take takes a token for each key of the request and returns the most
+// restrictive result. Requests are allowed if the store fails.
+func (rl *rateLimit) take(r *http.Request) RateLimitResult {
+	result := RateLimitResult{
+		Allowed:   true,
+		Limit:     rl.limit,
+		Remaining: rl.limit,
+	}
+
+	for _, k := range rl.keys {
+		key := k.key(r)
+		if key == "" {
+			continue
+		}
+
+		res, err := rl.store.Take(rl.name+":"+k.kind+":"+key, rl.limit, rl.window)
+		if err != nil {
+			continue
+		}
+
+		if !res.Allowed && (result.Allowed || res.RetryAfter > result.RetryAfter) {
+			result.Allowed = false
+			result.RetryAfter = res.RetryAfter
+		}
+		if res.Remaining < result.Remaining {
+			result.Remaining = res.Remaining
+		}
+		if res.Reset > result.Reset {
+			result.Reset = res.Reset
+		}
+	}
+
+	return result
+}
+
+// setHeaders sets the RateLimit headers (IETF draft) and Retry-After
+func (rl *rateLimit) setHeaders(h http.Header, res RateLimitResult) {
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", rl.limit, ceilSeconds(rl.window)))
+
+	if !res.Allowed {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// bodyField reads the field from the form or JSON body. The body is restored,
+// so handlers can read it again.
+func bodyField(r *http.Request, field string) string {
+	if r.Body == nil {
+		return r.URL.Query().Get(field)
+	}
+
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ct != "application/json" {
+		return r.FormValue(field)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+	r.Body = readCloser{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+	if err != nil {
+		return ""
+	}
+
+	var m map[string]interface{}
+	if json.Unmarshal(b, &m) != nil {
+		return ""
+	}
+
+	v, _ := m[field].(string)
+	return v
+}
+
+// readCloser restores a partially read body
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////// MEMORY RATE LIMIT STORE ////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
+// MemoryRateLimitStore is an in-memory token bucket RateLimitStore
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time
+}
+
+// NewMemoryRateLimitStore returns an empty in-memory rate limit store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Take takes a token from the bucket of the key
+func (s *MemoryRateLimitStore) Take(key string, limit int, window time.Duration) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	rate := float64(limit) / window.Seconds()
+
+	// Drop full buckets once in a while
+	if now.Sub(s.swept) > time.Minute {
+		for k, b := range s.buckets {
+			if now.After(b.full) {
+				delete(s.buckets, k)
+			}
+		}
+		s.swept = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(limit), last: now}
+		s.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(limit), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	res := RateLimitResult{Limit: limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+
+	res.Remaining = int(b.tokens)
+	res.Reset = time.Duration((float64(limit) - b.tokens) / rate * float64(time.Second))
+	b.full = now.Add(res.Reset)
+	return res, nil
+}
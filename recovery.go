@@ -0,0 +1,100 @@
+package goauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+)
+
+// RecoveryCodeStore stores the hashed recovery codes of each user
+type RecoveryCodeStore interface {
+	// SaveRecoveryCodes replaces all recovery codes of the user
+	SaveRecoveryCodes(user string, hashes []string) error
+
+	// RecoveryCodes returns the remaining hashed recovery codes of the user
+	RecoveryCodes(user string) ([]string, error)
+
+	// RemoveRecoveryCode removes a used recovery code of the user
+	RemoveRecoveryCode(user, hash string) error
+}
+
+type recovery struct {
+	n     int
+	store RecoveryCodeStore
+}
+
+// RecoveryCodes generates n one-time recovery codes on Context.RegisterTwoFA,
+// which can be used instead of a 2FA code if the user lost their device. Only
+// hashes of the codes are stored via the store.
+func RecoveryCodes(n int, store RecoveryCodeStore) AuthenticatorOption {
+	return func(auth *authenticator) {
+		if n <= 0 {
+			panic("The number of recovery codes must be > 0")
+		}
+
+		if store == nil {
+			panic("Recovery code store cannot be nil")
+		}
+
+		auth.recovery = &recovery{
+			n:     n,
+			store: store,
+		}
+	}
+}
+
+// generate generates and stores a new batch of recovery codes for the user,
+// which replaces all previous codes
+func (r *recovery) generate(user string) ([]string, error) {
+	codes := make([]string, r.n)
+	hashes := make([]string, r.n)
+	for i := range codes {
+		s, err := randomCryptoString(10)
+		if err != nil {
+			return nil, err
+		}
+
+		// 10 random bytes result in 16 base32 characters, use 10 of them
+		s = strings.ToLower(s[:10])
+		codes[i] = s[:5] + "-" + s[5:]
+		hashes[i] = hashRecoveryCode(s)
+	}
+
+	err := r.store.SaveRecoveryCodes(user, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// consume validates the code and removes it, so it can only be used once
+func (r *recovery) consume(user, code string) bool {
+	hashes, err := r.store.RecoveryCodes(user)
+	if err != nil {
+		return false
+	}
+
+	hash := hashRecoveryCode(code)
+	for _, h := range hashes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			return r.store.RemoveRecoveryCode(user, h) == nil
+		}
+	}
+
+	return false
+}
+
+func (r *recovery) remaining(user string) (int, error) {
+	hashes, err := r.store.RecoveryCodes(user)
+	return len(hashes), err
+}
+
+// hashRecoveryCode normalizes and hashes the code. Recovery codes carry 50 bits of
+// entropy, so a fast hash is sufficient.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	h := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(h[:])
+}
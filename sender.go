@@ -0,0 +1,124 @@
+package goauth
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a message delivered by a Sender
+type Message struct {
+	// To is the recipient, e.g. an email address or phone number
+	To string
+
+	// Subject is ignored by senders which don't support subjects, e.g. SMS
+	Subject string
+
+	Body string
+}
+
+// Sender delivers messages to users, e.g. via email or SMS
+type Sender interface {
+	Send(Message) error
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+/////////////////////////////////////// SMTP SENDER //////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
+// SMTPSender sends messages as plain text emails via SMTP
+type SMTPSender struct {
+	// Addr is the address of the SMTP server, e.g. smtp.example.com:587
+	// Required.
+	Addr string
+
+	// Auth is used to authenticate against the SMTP server, e.g. smtp.PlainAuth
+	Auth smtp.Auth
+
+	// From is the sender address
+	// Required.
+	From string
+}
+
+// Send sends the message via SMTP
+func (s *SMTPSender) Send(m Message) error {
+	to := stripHeader(m.To)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", stripHeader(s.From))
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", stripHeader(m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(m.Body)
+
+	return smtp.SendMail(s.Addr, s.Auth, s.From, []string{to}, []byte(b.String()))
+}
+
+// stripHeader removes line breaks to prevent header injection
+func stripHeader(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+////////////////////////////////////// TESTING SENDERS ///////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
+// LogSender writes messages to a logger instead of delivering them. Don't use
+// it in production, as the log contains the codes.
+type LogSender struct {
+	// Logger defaults to the standard logger
+	Logger *log.Logger
+}
+
+// Send logs the message
+func (s *LogSender) Send(m Message) error {
+	if s.Logger == nil {
+		log.Printf("goauth: message to %s: %s: %s", m.To, m.Subject, m.Body)
+		return nil
+	}
+
+	s.Logger.Printf("goauth: message to %s: %s: %s", m.To, m.Subject, m.Body)
+	return nil
+}
+
+// MemorySender records messages in memory, e.g. for tests
+type MemorySender struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// Send records the message
+func (s *MemorySender) Send(m Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = append(s.messages, m)
+	return nil
+}
+
+// Messages returns all recorded messages
+func (s *MemorySender) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Message(nil), s.messages...)
+}
+
+// Last returns the last message sent to the recipient
+func (s *MemorySender) Last(to string) (Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].To == to {
+			return s.messages[i], true
+		}
+	}
+
+	return Message{}, false
+}
@@ -1,6 +1,9 @@
 package goauth
 
-import "net/http"
+import (
+	"net/http"
+	"time"
+)
 
 // StatusUnauthorized returns a JSON response indicating the user is not authorized
 func StatusUnauthorized(err error) map[string]interface{} {
@@ -18,3 +21,100 @@ func StatusAuthorized() map[string]interface{} {
 		"authorized": "yes",
 	}
 }
+
+// StatusStepUp returns a JSON response asking the user to authenticate again
+// with the required max age and factors
+func StatusStepUp(err error, maxAge time.Duration, factors []string) map[string]interface{} {
+	s := map[string]interface{}{
+		"status":            http.StatusUnauthorized,
+		"authorized":        "no",
+		"error":             "insufficient_user_authentication",
+		"error_description": err.Error(),
+	}
+
+	if maxAge > 0 {
+		s["max_age"] = int64(maxAge.Seconds())
+	}
+
+	if len(factors) > 0 {
+		s["required_factors"] = factors
+	}
+
+	return s
+}
+
+// StatusPasswordPolicy returns a JSON response listing the violated rules of the
+// password policy
+func StatusPasswordPolicy(violations []PasswordViolation) map[string]interface{} {
+	return map[string]interface{}{
+		"status":     http.StatusUnprocessableEntity,
+		"error":      "password_policy",
+		"violations": violations,
+	}
+}
+
+// StatusTooManyRequests returns a JSON response indicating the client is rate
+// limited
+func StatusTooManyRequests(retryAfter time.Duration) map[string]interface{} {
+	return map[string]interface{}{
+		"status":      http.StatusTooManyRequests,
+		"error":       "Too many requests",
+		"retry_after": ceilSeconds(retryAfter),
+	}
+}
+
+// StatusForbidden returns a JSON response indicating the user lacks the
+// permission
+func StatusForbidden(err error) map[string]interface{} {
+	return map[string]interface{}{
+		"status":     http.StatusForbidden,
+		"authorized": "no",
+		"error":      err.Error(),
+	}
+}
+
+// StatusInsufficientScope returns a JSON response indicating the user lacks the
+// required roles or scopes
+func StatusInsufficientScope(err error, roles, scopes []string) map[string]interface{} {
+	s := map[string]interface{}{
+		"status":            http.StatusForbidden,
+		"authorized":        "no",
+		"error":             "insufficient_scope",
+		"error_description": err.Error(),
+	}
+
+	if len(roles) > 0 {
+		s["required_roles"] = roles
+	}
+
+	if len(scopes) > 0 {
+		s["required_scopes"] = scopes
+	}
+
+	return s
+}
+
+// StatusEmailVerified returns a JSON response indicating the email is verified
+func StatusEmailVerified(email string) map[string]interface{} {
+	return map[string]interface{}{
+		"status":         http.StatusOK,
+		"email":          email,
+		"email_verified": true,
+	}
+}
+
+// StatusError returns a JSON response with the status code and error
+func StatusError(code int, err error) map[string]interface{} {
+	return map[string]interface{}{
+		"status": code,
+		"error":  err.Error(),
+	}
+}
+
+// StatusTokenExchangeError returns a RFC 8693 / RFC 6749 error response
+func StatusTokenExchangeError(code string, err error) map[string]interface{} {
+	return map[string]interface{}{
+		"error":             code,
+		"error_description": err.Error(),
+	}
+}
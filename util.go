@@ -5,11 +5,15 @@ import (
 	"encoding/json"
 	"net/http"
 	"reflect"
+	"strings"
 )
 
 type Tag struct {
 	Name  string
 	Value interface{}
+
+	// Key is the JSON key of the field, empty for fields of nested structs
+	Key string
 }
 
 // Write JSON to response writer
@@ -37,36 +41,99 @@ func interfaceToMap(i interface{}, m *map[string]interface{}) error {
 	return err
 }
 
+// getTags returns the values of the fields tagged with goauth, including those
+// of embedded structs like Fields. Pointers are followed, maps are expected to
+// use the tag names as keys.
 func getTags(iface interface{}) map[string]interface{} {
 	m := make(map[string]interface{})
-	tags := getDeepTags(iface)
+	tags := getDeepTags(reflect.ValueOf(iface), true)
 	for i := 0; i < len(tags); i++ {
 		m[tags[i].Name] = tags[i].Value
 	}
 	return m
 }
 
-func getDeepTags(iface interface{}) []Tag {
+func getDeepTags(v reflect.Value, top bool) []Tag {
 	fields := make([]Tag, 0)
-	ifv := reflect.ValueOf(iface)
-	ift := reflect.TypeOf(iface)
-
-	for i := 0; i < ift.NumField(); i++ {
-		v := ifv.Field(i)
-
-		switch v.Kind() {
-		case reflect.Struct:
-			fields = append(fields, getDeepTags(v.Interface())...)
-		default:
-			if tag := ifv.Type().Field(i).Tag.Get("goauth"); tag != "" {
-				fields = append(fields, Tag{
-					Name:  tag,
-					Value: ifv.Field(i).Interface(),
-				})
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return fields
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fields
+		}
+
+		iter := v.MapRange()
+		for iter.Next() {
+			if !iter.Value().CanInterface() {
+				continue
+			}
+			fields = append(fields, Tag{
+				Name:  iter.Key().String(),
+				Value: iter.Value().Interface(),
+				Key:   iter.Key().String(),
+			})
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if tag := f.Tag.Get("goauth"); tag != "" {
+				if v.Field(i).CanInterface() {
+					t := Tag{
+						Name:  tag,
+						Value: v.Field(i).Interface(),
+					}
+					if top {
+						t.Key = jsonKey(f)
+					}
+					fields = append(fields, t)
+				}
+				continue
+			}
+
+			// Unexported fields can't be read, except the exported fields of
+			// embedded structs
+			if f.PkgPath != "" && !f.Anonymous {
+				continue
 			}
 
+			// Only embedded pointers are followed to avoid reference cycles
+			// Fields of embedded structs are flattened by encoding/json
+			flat := top && f.Anonymous && f.Tag.Get("json") == ""
+			if f.Type.Kind() == reflect.Struct || f.Anonymous && f.Type.Kind() == reflect.Ptr {
+				fields = append(fields, getDeepTags(v.Field(i), flat)...)
+			}
 		}
 	}
 
 	return fields
 }
+
+// jsonKey returns the key encoding/json uses for the field, empty if the field
+// is omitted
+func jsonKey(f reflect.StructField) string {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name
+	}
+	return f.Name
+}
+
+func containsString(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
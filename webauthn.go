@@ -0,0 +1,825 @@
+package goauth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authenticator data flags
+const (
+	webauthnFlagUserPresent  = 0x01
+	webauthnFlagUserVerified = 0x04
+	webauthnFlagAttested     = 0x40
+	webauthnFlagExtensions   = 0x80
+)
+
+// oidAAGUID is the FIDO AAGUID certificate extension
+var oidAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+// Base64URL is a byte slice which is encoded as unpadded base64url in JSON, as
+// used by the WebAuthn JSON serialization
+type Base64URL []byte
+
+// MarshalJSON encodes the bytes as unpadded base64url string
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// UnmarshalJSON decodes a base64url string, with or without padding
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	d, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+
+	*b = d
+	return nil
+}
+
+// WebauthnUser is the user a credential is registered for
+type WebauthnUser struct {
+	// ID is the user handle. It must not contain personally identifying
+	// information and is at most 64 bytes long.
+	ID []byte
+
+	// Name is a human-palatable identifier, e.g. the email address
+	Name string
+
+	// DisplayName is the name shown to the user, defaults to Name
+	DisplayName string
+}
+
+// WebauthnCredential is a registered WebAuthn credential
+type WebauthnCredential struct {
+	ID         []byte
+	UserHandle []byte
+
+	// PublicKey is the COSE encoded credential public key
+	PublicKey []byte
+
+	// SignCount is the last signature counter reported by the authenticator
+	SignCount uint32
+
+	AAGUID          []byte
+	AttestationType string
+	CreatedAt       time.Time
+}
+
+// CredentialStore stores WebAuthn credentials
+type CredentialStore interface {
+	// Credentials returns all credentials of the user handle
+	Credentials(user []byte) ([]WebauthnCredential, error)
+
+	// Credential returns the credential with the ID. If the credential doesn't
+	// exist, ErrorCredentialNotFound is returned.
+	Credential(id []byte) (WebauthnCredential, error)
+
+	// SaveCredential stores a new or updated credential
+	SaveCredential(WebauthnCredential) error
+}
+
+// CredentialCreationOptions are passed to navigator.credentials.create()
+type CredentialCreationOptions struct {
+	PublicKey struct {
+		Challenge              Base64URL              `json:"challenge"`
+		RP                     relyingParty           `json:"rp"`
+		User                   userEntity             `json:"user"`
+		PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+		Timeout                int64                  `json:"timeout,omitempty"`
+		ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+		AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+		Attestation            string                 `json:"attestation,omitempty"`
+	} `json:"publicKey"`
+}
+
+// CredentialRequestOptions are passed to navigator.credentials.get()
+type CredentialRequestOptions struct {
+	PublicKey struct {
+		Challenge        Base64URL              `json:"challenge"`
+		Timeout          int64                  `json:"timeout,omitempty"`
+		RPID             string                 `json:"rpId"`
+		AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+		UserVerification string                 `json:"userVerification,omitempty"`
+	} `json:"publicKey"`
+}
+
+// CredentialDescriptor identifies a credential
+type CredentialDescriptor struct {
+	Type string    `json:"type"`
+	ID   Base64URL `json:"id"`
+}
+
+type relyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type userEntity struct {
+	ID          Base64URL `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"displayName"`
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey,omitempty"`
+	UserVerification string `json:"userVerification,omitempty"`
+}
+
+// credentialResponse is the JSON serialization of a PublicKeyCredential
+type credentialResponse struct {
+	ID       string    `json:"id"`
+	RawID    Base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AttestationObject Base64URL `json:"attestationObject"`
+		AuthenticatorData Base64URL `json:"authenticatorData"`
+		Signature         Base64URL `json:"signature"`
+		UserHandle        Base64URL `json:"userHandle"`
+	} `json:"response"`
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authenticatorData struct {
+	raw        []byte
+	rpIDHash   []byte
+	flags      byte
+	signCount  uint32
+	aaguid     []byte
+	credential []byte
+	publicKey  []byte
+	key        *coseKey
+}
+
+type webauthnSession struct {
+	ceremony  string
+	user      []byte
+	requireUV bool
+	expires   time.Time
+}
+
+// Webauthn is the WebAuthn 2FA and passwordless login method
+type Webauthn struct {
+	rpID             string
+	rpName           string
+	origins          []string
+	timeout          time.Duration
+	userVerification string
+	store            CredentialStore
+
+	mu       sync.Mutex
+	sessions map[string]webauthnSession
+}
+
+// WebAuthn registers WebAuthn as a 2FA method. The relying party ID is the
+// effective domain of your site, e.g. example.com, and origins are the origins
+// the ceremonies may be performed on, e.g. https://login.example.com
+func WebAuthn(rpID, rpName string, origins []string, store CredentialStore) AuthenticatorOption {
+	return func(auth *authenticator) {
+		auth.twoFaMethods["webauthn"] = newWebauthn(rpID, rpName, origins, store)
+	}
+}
+
+// Passkeys enables passwordless login with discoverable WebAuthn credentials.
+// The resolve function returns your user for the user handle of the credential.
+// WebAuthn has to be registered as well.
+func Passkeys(resolve func(handle []byte) (interface{}, error)) AuthenticatorOption {
+	return func(auth *authenticator) {
+		auth.passkeys = resolve
+	}
+}
+
+func newWebauthn(rpID, rpName string, origins []string, store CredentialStore) *Webauthn {
+	if rpID == "" {
+		panic("Relying party ID cannot be empty")
+	}
+
+	if len(origins) == 0 {
+		panic("At least one origin is required")
+	}
+
+	if store == nil {
+		panic("Credential store cannot be nil")
+	}
+
+	return &Webauthn{
+		rpID:             rpID,
+		rpName:           rpName,
+		origins:          origins,
+		timeout:          2 * time.Minute,
+		userVerification: "preferred",
+		store:            store,
+		sessions:         make(map[string]webauthnSession),
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////// 2FA METHOD //////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
+// Generate starts an authentication ceremony for the account and returns the
+// JSON encoded CredentialRequestOptions
+func (w *Webauthn) Generate(account string) (string, error) {
+	opts, err := w.BeginLogin(WebauthnUserHandle(account))
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(opts)
+	return string(b), err
+}
+
+// Register starts a registration ceremony for the account. It returns the base64
+// encoded user handle, which is used as the 2FA secret of the user, and the
+// JSON encoded CredentialCreationOptions. The ceremony is completed with
+// FinishRegistration.
+func (w *Webauthn) Register(account string) (string, string, error) {
+	user := WebauthnUser{
+		ID:   WebauthnUserHandle(account),
+		Name: account,
+	}
+
+	opts, err := w.BeginRegistration(user)
+	if err != nil {
+		return "", "", err
+	}
+
+	b, err := json.Marshal(opts)
+	if err != nil {
+		return "", "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(user.ID), string(b), nil
+}
+
+// Secret returns a random challenge
+func (w *Webauthn) Secret() ([]byte, error) {
+	return randomCryptoBytes(32)
+}
+
+// Validate validates the JSON encoded assertion response (code) for the base64
+// encoded user handle (secret)
+func (w *Webauthn) Validate(code, secret string) bool {
+	handle, err := base64.RawURLEncoding.DecodeString(secret)
+	if err != nil {
+		return false
+	}
+
+	_, err = w.FinishLogin(handle, []byte(code))
+	return err == nil
+}
+
+// ConfirmUser completes the registration ceremony started by Register with the
+// JSON encoded attestation response (code) for the base64 encoded user handle
+// (secret)
+func (w *Webauthn) ConfirmUser(user, code, secret string) bool {
+	handle, err := base64.RawURLEncoding.DecodeString(secret)
+	if err != nil {
+		return false
+	}
+
+	_, err = w.FinishRegistration(WebauthnUser{ID: handle, Name: user}, []byte(code))
+	return err == nil
+}
+
+// WebauthnUserHandle derives the user handle used by the WebAuthn 2FA method
+// from the account name
+func WebauthnUserHandle(account string) []byte {
+	h := sha256.Sum256([]byte(account))
+	return h[:]
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////// CEREMONIES //////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
+// BeginRegistration starts a registration ceremony for the user
+func (w *Webauthn) BeginRegistration(user WebauthnUser) (*CredentialCreationOptions, error) {
+	if len(user.ID) == 0 || len(user.ID) > 64 {
+		return nil, ErrorWebauthnUserHandle
+	}
+
+	creds, err := w.store.Credentials(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := w.newSession("webauthn.create", user.ID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.DisplayName == "" {
+		user.DisplayName = user.Name
+	}
+
+	opts := &CredentialCreationOptions{}
+	opts.PublicKey.Challenge = challenge
+	opts.PublicKey.RP = relyingParty{ID: w.rpID, Name: w.rpName}
+	opts.PublicKey.User = userEntity{ID: user.ID, Name: user.Name, DisplayName: user.DisplayName}
+	opts.PublicKey.PubKeyCredParams = []credentialParameter{
+		{Type: "public-key", Alg: COSEAlgorithmES256},
+		{Type: "public-key", Alg: COSEAlgorithmEdDSA},
+		{Type: "public-key", Alg: COSEAlgorithmRS256},
+	}
+	opts.PublicKey.Timeout = w.timeout.Milliseconds()
+	opts.PublicKey.ExcludeCredentials = descriptors(creds)
+	opts.PublicKey.AuthenticatorSelection = authenticatorSelection{
+		ResidentKey:      "preferred",
+		UserVerification: w.userVerification,
+	}
+	opts.PublicKey.Attestation = "direct"
+
+	return opts, nil
+}
+
+// FinishRegistration verifies the JSON encoded attestation response of the
+// client and stores the new credential. Supported attestation formats are
+// "none" and "packed".
+func (w *Webauthn) FinishRegistration(user WebauthnUser, response []byte) (*WebauthnCredential, error) {
+	var res credentialResponse
+	if err := json.Unmarshal(response, &res); err != nil {
+		return nil, ErrorWebauthnResponse
+	}
+
+	session, err := w.verifyClientData(res.Response.ClientDataJSON, "webauthn.create")
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(session.user, user.ID) {
+		return nil, ErrorWebauthnChallenge
+	}
+
+	v, _, err := cborDecode(res.Response.AttestationObject)
+	if err != nil {
+		return nil, ErrorWebauthnResponse
+	}
+
+	obj, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrorWebauthnResponse
+	}
+
+	format, _ := obj["fmt"].(string)
+	raw, _ := obj["authData"].([]byte)
+	stmt, _ := obj["attStmt"].(map[interface{}]interface{})
+
+	data, err := w.parseAuthenticatorData(raw, session.requireUV)
+	if err != nil {
+		return nil, err
+	}
+
+	if data.key == nil || len(data.credential) == 0 {
+		return nil, ErrorWebauthnResponse
+	}
+
+	if !bytes.Equal(data.credential, res.RawID) {
+		return nil, ErrorWebauthnResponse
+	}
+
+	hash := sha256.Sum256(res.Response.ClientDataJSON)
+	err = verifyAttestation(format, stmt, data, hash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.store.Credential(data.credential)
+	switch err {
+	case ErrorCredentialNotFound:
+	case nil:
+		return nil, ErrorCredentialExists
+	default:
+		return nil, err
+	}
+
+	cred := WebauthnCredential{
+		ID:              data.credential,
+		UserHandle:      user.ID,
+		PublicKey:       data.publicKey,
+		SignCount:       data.signCount,
+		AAGUID:          data.aaguid,
+		AttestationType: format,
+		CreatedAt:       time.Now(),
+	}
+
+	err = w.store.SaveCredential(cred)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cred, nil
+}
+
+// BeginLogin starts an authentication ceremony. If user is nil, the ceremony is
+// started for discoverable credentials (passwordless login) and user
+// verification is required.
+func (w *Webauthn) BeginLogin(user []byte) (*CredentialRequestOptions, error) {
+	var creds []WebauthnCredential
+	if user != nil {
+		var err error
+		creds, err = w.store.Credentials(user)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(creds) == 0 {
+			return nil, ErrorCredentialNotFound
+		}
+	}
+
+	challenge, err := w.newSession("webauthn.get", user, user == nil)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &CredentialRequestOptions{}
+	opts.PublicKey.Challenge = challenge
+	opts.PublicKey.Timeout = w.timeout.Milliseconds()
+	opts.PublicKey.RPID = w.rpID
+	opts.PublicKey.AllowCredentials = descriptors(creds)
+	opts.PublicKey.UserVerification = w.userVerification
+	if user == nil {
+		opts.PublicKey.UserVerification = "required"
+	}
+
+	return opts, nil
+}
+
+// FinishLogin verifies the JSON encoded assertion response of the client and
+// updates the signature counter of the credential. If user is nil, the user is
+// identified by the user handle in the response.
+func (w *Webauthn) FinishLogin(user []byte, response []byte) (*WebauthnCredential, error) {
+	var res credentialResponse
+	if err := json.Unmarshal(response, &res); err != nil {
+		return nil, ErrorWebauthnResponse
+	}
+
+	session, err := w.verifyClientData(res.Response.ClientDataJSON, "webauthn.get")
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(session.user, user) {
+		return nil, ErrorWebauthnChallenge
+	}
+
+	cred, err := w.store.Credential(res.RawID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		user = res.Response.UserHandle
+	}
+
+	if len(user) == 0 || !bytes.Equal(cred.UserHandle, user) ||
+		(len(res.Response.UserHandle) != 0 && !bytes.Equal(res.Response.UserHandle, user)) {
+		return nil, ErrorCredentialNotFound
+	}
+
+	data, err := w.parseAuthenticatorData(res.Response.AuthenticatorData, session.requireUV)
+	if err != nil {
+		return nil, err
+	}
+
+	key, _, err := parseCOSEKey(cred.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(res.Response.ClientDataJSON)
+	signed := append(append([]byte{}, data.raw...), hash[:]...)
+	err = key.verify(signed, res.Response.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	// A counter which didn't increase indicates a cloned authenticator. Authenticators
+	// which don't implement a counter always report 0.
+	if data.signCount != 0 || cred.SignCount != 0 {
+		if data.signCount <= cred.SignCount {
+			return nil, ErrorWebauthnSignCount
+		}
+	}
+
+	cred.SignCount = data.signCount
+	err = w.store.SaveCredential(cred)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cred, nil
+}
+
+// newSession stores a new challenge for a ceremony
+func (w *Webauthn) newSession(ceremony string, user []byte, requireUV bool) ([]byte, error) {
+	challenge, err := randomCryptoBytes(32)
+	if err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	for k, s := range w.sessions {
+		if now.After(s.expires) {
+			delete(w.sessions, k)
+		}
+	}
+
+	w.sessions[base64.RawURLEncoding.EncodeToString(challenge)] = webauthnSession{
+		ceremony:  ceremony,
+		user:      user,
+		requireUV: requireUV || w.userVerification == "required",
+		expires:   now.Add(w.timeout),
+	}
+
+	return challenge, nil
+}
+
+// verifyClientData verifies the client data and consumes the session of its
+// challenge
+func (w *Webauthn) verifyClientData(raw []byte, ceremony string) (webauthnSession, error) {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return webauthnSession{}, ErrorWebauthnResponse
+	}
+
+	if cd.Type != ceremony {
+		return webauthnSession{}, ErrorWebauthnResponse
+	}
+
+	if !containsString(w.origins, cd.Origin) {
+		return webauthnSession{}, ErrorWebauthnOrigin
+	}
+
+	challenge := strings.TrimRight(cd.Challenge, "=")
+
+	w.mu.Lock()
+	session, ok := w.sessions[challenge]
+	delete(w.sessions, challenge)
+	w.mu.Unlock()
+
+	if !ok || session.ceremony != ceremony || time.Now().After(session.expires) {
+		return webauthnSession{}, ErrorWebauthnChallenge
+	}
+
+	return session, nil
+}
+
+// parseAuthenticatorData parses and verifies the authenticator data
+func (w *Webauthn) parseAuthenticatorData(raw []byte, requireUV bool) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, ErrorWebauthnResponse
+	}
+
+	data := &authenticatorData{
+		raw:       raw,
+		rpIDHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+
+	rpIDHash := sha256.Sum256([]byte(w.rpID))
+	if subtle.ConstantTimeCompare(data.rpIDHash, rpIDHash[:]) != 1 {
+		return nil, ErrorWebauthnRelyingParty
+	}
+
+	if data.flags&webauthnFlagUserPresent == 0 {
+		return nil, ErrorWebauthnUserPresence
+	}
+
+	if requireUV && data.flags&webauthnFlagUserVerified == 0 {
+		return nil, ErrorWebauthnUserVerification
+	}
+
+	rest := raw[37:]
+	if data.flags&webauthnFlagAttested != 0 {
+		if len(rest) < 18 {
+			return nil, ErrorWebauthnResponse
+		}
+
+		data.aaguid = rest[:16]
+		l := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if l == 0 || l > 1023 || len(rest) < l {
+			return nil, ErrorWebauthnResponse
+		}
+
+		data.credential = rest[:l]
+		rest = rest[l:]
+
+		key, n, err := parseCOSEKey(rest)
+		if err != nil {
+			return nil, err
+		}
+
+		data.key = key
+		data.publicKey = rest[:n]
+		rest = rest[n:]
+	}
+
+	if data.flags&webauthnFlagExtensions != 0 {
+		_, n, err := cborDecode(rest)
+		if err != nil {
+			return nil, ErrorWebauthnResponse
+		}
+		rest = rest[n:]
+	}
+
+	if len(rest) != 0 {
+		return nil, ErrorWebauthnResponse
+	}
+
+	return data, nil
+}
+
+// verifyAttestation verifies the attestation statement of the format
+func verifyAttestation(format string, stmt map[interface{}]interface{}, data *authenticatorData, clientDataHash []byte) error {
+	switch format {
+	case "none":
+		if len(stmt) != 0 {
+			return ErrorWebauthnAttestation
+		}
+		return nil
+	case "packed":
+		alg, _ := stmt["alg"].(int64)
+		sig, _ := stmt["sig"].([]byte)
+		signed := append(append([]byte{}, data.raw...), clientDataHash...)
+
+		x5c, ok := stmt["x5c"].([]interface{})
+		if !ok {
+			// Self attestation, signed with the credential private key
+			if alg != data.key.alg {
+				return ErrorWebauthnAttestation
+			}
+			return data.key.verify(signed, sig)
+		}
+
+		if len(x5c) == 0 {
+			return ErrorWebauthnAttestation
+		}
+
+		der, _ := x5c[0].([]byte)
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return ErrorWebauthnAttestation
+		}
+
+		if err = verifySignature(cert.PublicKey, alg, signed, sig); err != nil {
+			return err
+		}
+
+		// Packed attestation certificate requirements, WebAuthn §8.2.1
+		if cert.Version != 3 || cert.IsCA ||
+			!containsString(cert.Subject.OrganizationalUnit, "Authenticator Attestation") {
+			return ErrorWebauthnAttestation
+		}
+
+		for _, ext := range cert.Extensions {
+			if !ext.Id.Equal(oidAAGUID) {
+				continue
+			}
+
+			var aaguid []byte
+			if _, err := asn1.Unmarshal(ext.Value, &aaguid); err != nil || !bytes.Equal(aaguid, data.aaguid) {
+				return ErrorWebauthnAttestation
+			}
+		}
+
+		return nil
+	default:
+		return ErrorWebauthnAttestation
+	}
+}
+
+func descriptors(creds []WebauthnCredential) []CredentialDescriptor {
+	d := make([]CredentialDescriptor, 0, len(creds))
+	for _, c := range creds {
+		d = append(d, CredentialDescriptor{Type: "public-key", ID: c.ID})
+	}
+	return d
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////// PASSKEYS ///////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
+// BeginPasskeyLogin starts a passwordless login with a discoverable credential
+func (auth *authenticator) BeginPasskeyLogin() (*CredentialRequestOptions, error) {
+	w, ok := auth.twoFaMethods["webauthn"].(*Webauthn)
+	if !ok || auth.passkeys == nil {
+		return nil, ErrorPasskeysDisabled
+	}
+
+	return w.BeginLogin(nil)
+}
+
+// IdentifyPasskey verifies the JSON encoded assertion response of a passwordless
+// login and returns a context for the user owning the credential. The credential
+// is multi-factor, so the context doesn't require an additional 2FA validation.
+func (auth *authenticator) IdentifyPasskey(response []byte) (Context, error) {
+	w, ok := auth.twoFaMethods["webauthn"].(*Webauthn)
+	if !ok || auth.passkeys == nil {
+		return nil, ErrorPasskeysDisabled
+	}
+
+	cred, err := w.FinishLogin(nil, response)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := auth.passkeys(cred.UserHandle)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := userMap(user)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := auth.newContext(m)
+	ctx.twoFAValid = true
+	ctx.amr = []string{"hwk", "mfa"}
+	return ctx, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////// MEMORY STORE ////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////
+
+// MemoryCredentialStore is an in-memory CredentialStore, e.g. for development
+// and tests
+type MemoryCredentialStore struct {
+	mu    sync.RWMutex
+	creds map[string]WebauthnCredential
+}
+
+// NewMemoryCredentialStore returns an empty in-memory credential store
+func NewMemoryCredentialStore() *MemoryCredentialStore {
+	return &MemoryCredentialStore{
+		creds: make(map[string]WebauthnCredential),
+	}
+}
+
+// Credentials returns all credentials of the user handle
+func (s *MemoryCredentialStore) Credentials(user []byte) ([]WebauthnCredential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	creds := make([]WebauthnCredential, 0)
+	for _, c := range s.creds {
+		if bytes.Equal(c.UserHandle, user) {
+			creds = append(creds, c)
+		}
+	}
+	return creds, nil
+}
+
+// Credential returns the credential with the ID
+func (s *MemoryCredentialStore) Credential(id []byte) (WebauthnCredential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.creds[string(id)]
+	if !ok {
+		return WebauthnCredential{}, ErrorCredentialNotFound
+	}
+	return c, nil
+}
+
+// SaveCredential stores the credential
+func (s *MemoryCredentialStore) SaveCredential(c WebauthnCredential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.creds[string(c.ID)] = c
+	return nil
+}
@@ -0,0 +1,437 @@
+package goauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"sort"
+	"testing"
+	"time"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+// softAuthenticator is a software WebAuthn authenticator with a single
+// credential
+type softAuthenticator struct {
+	alg       int64
+	key       crypto.Signer
+	id        []byte
+	signCount uint32
+
+	// attestation signs packed attestation statements instead of the
+	// credential key, if set
+	attestation *softAuthenticator
+}
+
+func newSoftAuthenticator(t *testing.T, alg int64) *softAuthenticator {
+	var (
+		key crypto.Signer
+		err error
+	)
+
+	switch alg {
+	case COSEAlgorithmES256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case COSEAlgorithmRS256:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case COSEAlgorithmEdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+
+	return &softAuthenticator{alg: alg, key: key, id: id}
+}
+
+// publicKey returns the COSE encoded public key
+func (a *softAuthenticator) publicKey() []byte {
+	switch pub := a.key.Public().(type) {
+	case *ecdsa.PublicKey:
+		return cborEncode(map[int64]interface{}{
+			coseKeyType:   int64(coseKeyTypeEC2),
+			coseAlgorithm: a.alg,
+			coseCurve:     int64(coseCurveP256),
+			coseX:         pad32(pub.X),
+			coseY:         pad32(pub.Y),
+		})
+	case *rsa.PublicKey:
+		return cborEncode(map[int64]interface{}{
+			coseKeyType:    int64(coseKeyTypeRSA),
+			coseAlgorithm:  a.alg,
+			coseRSAModulus: pub.N.Bytes(),
+			coseRSAExp:     big.NewInt(int64(pub.E)).Bytes(),
+		})
+	default:
+		return cborEncode(map[int64]interface{}{
+			coseKeyType:   int64(coseKeyTypeOKP),
+			coseAlgorithm: a.alg,
+			coseCurve:     int64(coseCurveEd25519),
+			coseX:         []byte(a.key.Public().(ed25519.PublicKey)),
+		})
+	}
+}
+
+func (a *softAuthenticator) sign(t *testing.T, data []byte) []byte {
+	var (
+		sig []byte
+		err error
+	)
+
+	if a.alg == COSEAlgorithmEdDSA {
+		sig, err = a.key.Sign(rand.Reader, data, crypto.Hash(0))
+	} else {
+		h := sha256.Sum256(data)
+		sig, err = a.key.Sign(rand.Reader, h[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sig
+}
+
+// authData returns the authenticator data, including the attested credential
+// data if attested is true
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append([]byte{}, rpIDHash[:]...)
+
+	flags := byte(webauthnFlagUserPresent | webauthnFlagUserVerified)
+	if attested {
+		flags |= webauthnFlagAttested
+	}
+	data = append(data, flags)
+	data = append(data, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], a.signCount)
+
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = append(data, byte(len(a.id)>>8), byte(len(a.id)))
+		data = append(data, a.id...)
+		data = append(data, a.publicKey()...)
+	}
+
+	return data
+}
+
+// create returns the JSON encoded attestation response for the options
+func (a *softAuthenticator) create(t *testing.T, opts *CredentialCreationOptions, format string) []byte {
+	cd := clientDataJSON(t, "webauthn.create", opts.PublicKey.Challenge)
+	authData := a.authData(true)
+
+	stmt := map[string]interface{}{}
+	if format == "packed" {
+		signer := a
+		if a.attestation != nil {
+			signer = a.attestation
+		}
+
+		hash := sha256.Sum256(cd)
+		stmt["alg"] = a.alg
+		stmt["sig"] = signer.sign(t, append(append([]byte{}, authData...), hash[:]...))
+	}
+
+	res := map[string]interface{}{
+		"id":    base64.RawURLEncoding.EncodeToString(a.id),
+		"rawId": Base64URL(a.id),
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON": Base64URL(cd),
+			"attestationObject": Base64URL(cborEncode(map[string]interface{}{
+				"fmt":      format,
+				"authData": authData,
+				"attStmt":  stmt,
+			})),
+		},
+	}
+
+	b, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// get returns the JSON encoded assertion response for the options
+func (a *softAuthenticator) get(t *testing.T, opts *CredentialRequestOptions) []byte {
+	cd := clientDataJSON(t, "webauthn.get", opts.PublicKey.Challenge)
+	authData := a.authData(false)
+	hash := sha256.Sum256(cd)
+
+	res := map[string]interface{}{
+		"id":    base64.RawURLEncoding.EncodeToString(a.id),
+		"rawId": Base64URL(a.id),
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    Base64URL(cd),
+			"authenticatorData": Base64URL(authData),
+			"signature":         Base64URL(a.sign(t, append(append([]byte{}, authData...), hash[:]...))),
+		},
+	}
+
+	b, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func clientDataJSON(t *testing.T, ceremony string, challenge []byte) []byte {
+	b, err := json.Marshal(clientData{
+		Type:      ceremony,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    testOrigin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func pad32(i *big.Int) []byte {
+	b := i.Bytes()
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+// cborEncode encodes the subset of CBOR needed for attestation objects and
+// COSE keys. Map keys are sorted, so the encoding is deterministic.
+func cborEncode(v interface{}) []byte {
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case map[int64]interface{}:
+		keys := make([]int64, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+		b := cborHead(5, uint64(len(v)))
+		for _, k := range keys {
+			b = append(b, cborEncode(k)...)
+			b = append(b, cborEncode(v[k])...)
+		}
+		return b
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		b := cborHead(5, uint64(len(v)))
+		for _, k := range keys {
+			b = append(b, cborEncode(k)...)
+			b = append(b, cborEncode(v[k])...)
+		}
+		return b
+	default:
+		panic("unsupported CBOR type")
+	}
+}
+
+func cborHead(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg <= 0xffff:
+		return []byte{major<<5 | 25, byte(arg >> 8), byte(arg)}
+	default:
+		b := make([]byte, 5)
+		b[0] = major<<5 | 26
+		binary.BigEndian.PutUint32(b[1:], uint32(arg))
+		return b
+	}
+}
+
+var testAlgorithms = []struct {
+	name string
+	alg  int64
+}{
+	{"ES256", COSEAlgorithmES256},
+	{"RS256", COSEAlgorithmRS256},
+	{"EdDSA", COSEAlgorithmEdDSA},
+}
+
+// register registers a new credential of the authenticator for the account
+func register(t *testing.T, w *Webauthn, a *softAuthenticator, account, format string) *WebauthnCredential {
+	user := WebauthnUser{ID: WebauthnUserHandle(account), Name: account}
+	opts, err := w.BeginRegistration(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cred, err := w.FinishRegistration(user, a.create(t, opts, format))
+	if err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+	return cred
+}
+
+func TestWebauthnRegistration(t *testing.T) {
+	for _, format := range []string{"none", "packed"} {
+		for _, tt := range testAlgorithms {
+			t.Run(format+"/"+tt.name, func(t *testing.T) {
+				w := newWebauthn(testRPID, "Example", []string{testOrigin}, NewMemoryCredentialStore())
+				a := newSoftAuthenticator(t, tt.alg)
+
+				cred := register(t, w, a, "alice", format)
+				if cred.AttestationType != format {
+					t.Errorf("attestation type = %q, want %q", cred.AttestationType, format)
+				}
+
+				key, _, err := parseCOSEKey(cred.PublicKey)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if key.alg != tt.alg {
+					t.Errorf("alg = %d, want %d", key.alg, tt.alg)
+				}
+			})
+		}
+	}
+}
+
+func TestWebauthnPackedAttestationInvalidSignature(t *testing.T) {
+	w := newWebauthn(testRPID, "Example", []string{testOrigin}, NewMemoryCredentialStore())
+	a := newSoftAuthenticator(t, COSEAlgorithmES256)
+	user := WebauthnUser{ID: WebauthnUserHandle("alice"), Name: "alice"}
+
+	opts, err := w.BeginRegistration(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Self attestation signed by another key than the credential key
+	a.attestation = newSoftAuthenticator(t, COSEAlgorithmES256)
+	_, err = w.FinishRegistration(user, a.create(t, opts, "packed"))
+	if err != ErrorWebauthnSignature {
+		t.Errorf("err = %v, want %v", err, ErrorWebauthnSignature)
+	}
+}
+
+func TestWebauthnLogin(t *testing.T) {
+	for _, tt := range testAlgorithms {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newWebauthn(testRPID, "Example", []string{testOrigin}, NewMemoryCredentialStore())
+			a := newSoftAuthenticator(t, tt.alg)
+			register(t, w, a, "alice", "packed")
+
+			handle := WebauthnUserHandle("alice")
+			for i := 1; i <= 2; i++ {
+				opts, err := w.BeginLogin(handle)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				a.signCount = uint32(i)
+				cred, err := w.FinishLogin(handle, a.get(t, opts))
+				if err != nil {
+					t.Fatalf("FinishLogin: %v", err)
+				}
+				if cred.SignCount != uint32(i) {
+					t.Errorf("sign count = %d, want %d", cred.SignCount, i)
+				}
+			}
+		})
+	}
+}
+
+func TestWebauthnSignCountRegression(t *testing.T) {
+	w := newWebauthn(testRPID, "Example", []string{testOrigin}, NewMemoryCredentialStore())
+	a := newSoftAuthenticator(t, COSEAlgorithmES256)
+	handle := WebauthnUserHandle("alice")
+
+	a.signCount = 5
+	register(t, w, a, "alice", "none")
+
+	for _, count := range []uint32{5, 4, 0} {
+		opts, err := w.BeginLogin(handle)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a.signCount = count
+		_, err = w.FinishLogin(handle, a.get(t, opts))
+		if err != ErrorWebauthnSignCount {
+			t.Errorf("sign count %d: err = %v, want %v", count, err, ErrorWebauthnSignCount)
+		}
+	}
+
+	opts, err := w.BeginLogin(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.signCount = 6
+	if _, err = w.FinishLogin(handle, a.get(t, opts)); err != nil {
+		t.Errorf("FinishLogin: %v", err)
+	}
+}
+
+func TestWebauthnEnrollmentConfirmation(t *testing.T) {
+	w := newWebauthn(testRPID, "Example", []string{testOrigin}, NewMemoryCredentialStore())
+	a := newSoftAuthenticator(t, COSEAlgorithmEdDSA)
+
+	var confirmed string
+	auth := &authenticator{}
+	TwoFAEnrollment(time.Minute, func(user, method, secret string) error {
+		confirmed = method
+		return nil
+	})(auth)
+
+	secret, options, err := w.Register("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var opts CredentialCreationOptions
+	if err = json.Unmarshal([]byte(options), &opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = auth.enrollment.hold("alice", "webauthn", secret); err != nil {
+		t.Fatal(err)
+	}
+
+	method := func(string) TwoFAMethod { return w }
+	_, _, err = auth.enrollment.complete("alice", string(a.create(t, &opts, "none")), method, nil)
+	if err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if confirmed != "webauthn" {
+		t.Errorf("confirmed method = %q, want webauthn", confirmed)
+	}
+
+	creds, err := w.store.Credentials(WebauthnUserHandle("alice"))
+	if err != nil || len(creds) != 1 {
+		t.Fatalf("credentials = %d, %v, want 1", len(creds), err)
+	}
+}